@@ -0,0 +1,175 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+// Preset generates the rules of a well-known registry setup. Exactly one
+// preset must be set.
+// +kubebuilder:validation:MinProperties=1
+// +kubebuilder:validation:MaxProperties=1
+type Preset struct {
+	// ECRPullThroughCache redirects upstream registries to the pull through
+	// cache rules of an Amazon ECR private registry
+	// +kubebuilder:validation:Optional
+	ECRPullThroughCache *ECRPullThroughCachePreset `json:"ecrPullThroughCache,omitempty"`
+
+	// HarborProxyCache redirects upstream registries to the proxy cache
+	// projects of a Harbor registry
+	// +kubebuilder:validation:Optional
+	HarborProxyCache *HarborProxyCachePreset `json:"harborProxyCache,omitempty"`
+
+	// ArtifactoryRemote redirects upstream registries to the remote Docker
+	// repositories of a JFrog Artifactory instance
+	// +kubebuilder:validation:Optional
+	ArtifactoryRemote *ArtifactoryRemotePreset `json:"artifactoryRemote,omitempty"`
+
+	// ArtifactRegistryRemote redirects upstream registries to the remote
+	// repositories of Google Artifact Registry
+	// +kubebuilder:validation:Optional
+	ArtifactRegistryRemote *ArtifactRegistryRemotePreset `json:"artifactRegistryRemote,omitempty"`
+
+	// Mirror redirects arbitrary upstream registries to a registry mirroring
+	// them
+	// +kubebuilder:validation:Optional
+	Mirror *MirrorPreset `json:"mirror,omitempty"`
+}
+
+// ECRPullThroughCachePreset configures the rules redirecting upstream
+// registries to Amazon ECR pull through cache rules
+type ECRPullThroughCachePreset struct {
+	// AccountID is the AWS account ID of the private registry
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[0-9]{12}$`
+	AccountID string `json:"accountId"`
+
+	// Region is the AWS region of the private registry
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-z]{2}(-[a-z]+)+-[0-9]+$`
+	Region string `json:"region"`
+
+	// Upstreams maps each cached upstream registry to the ECR repository
+	// prefix of its pull through cache rule
+	// +kubebuilder:validation:Required
+	Upstreams Upstreams `json:"upstreams"`
+}
+
+// HarborProxyCachePreset configures the rules redirecting upstream
+// registries to Harbor proxy cache projects
+type HarborProxyCachePreset struct {
+	// Registry is the Harbor registry host, with its port
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Registry string `json:"registry"`
+
+	// Projects maps each cached upstream registry to its proxy cache project
+	// +kubebuilder:validation:Required
+	Projects Upstreams `json:"projects"`
+}
+
+// ArtifactoryRemotePreset configures the rules redirecting upstream
+// registries to Artifactory remote Docker repositories, accessed with the
+// repository path method
+type ArtifactoryRemotePreset struct {
+	// Registry is the Artifactory host, with its port
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Registry string `json:"registry"`
+
+	// Repositories maps each cached upstream registry to the key of its
+	// remote repository
+	// +kubebuilder:validation:Required
+	Repositories Upstreams `json:"repositories"`
+}
+
+// ArtifactRegistryRemotePreset configures the rules redirecting upstream
+// registries to Google Artifact Registry remote repositories
+type ArtifactRegistryRemotePreset struct {
+	// Location is the region or multi-region of the repositories, such as
+	// europe-west1 or us
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-z]+[0-9]*(-[a-z]+[0-9]+)?$`
+	Location string `json:"location"`
+
+	// Project is the ID of the Google Cloud project of the repositories
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-z][a-z0-9-]{4,28}[a-z0-9]$`
+	Project string `json:"project"`
+
+	// Repositories maps each cached upstream registry to the name of its
+	// remote repository
+	// +kubebuilder:validation:Required
+	Repositories Upstreams `json:"repositories"`
+}
+
+// MirrorPreset configures the rules redirecting upstream registries to a
+// registry mirroring them under a path prefix
+type MirrorPreset struct {
+	// Registry is the mirror host, with its port
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Registry string `json:"registry"`
+
+	// Upstreams lists the mirrored registries
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	Upstreams []MirrorUpstream `json:"upstreams"`
+}
+
+// MirrorUpstream is a registry mirrored by a MirrorPreset
+type MirrorUpstream struct {
+	// Registry is the upstream registry host, with its port. Docker Hub is
+	// docker.io.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Registry string `json:"registry"`
+
+	// Prefix is the path under which the mirror serves the repositories of
+	// the upstream. Repositories are served at the root when empty.
+	// +kubebuilder:validation:Optional
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// Upstreams are the path prefixes of the caches of well-known upstream
+// registries, such as ECR repository prefixes or Harbor projects. Upstreams
+// without a prefix aren't redirected.
+// +kubebuilder:validation:MinProperties=1
+type Upstreams struct {
+	// DockerHub is the prefix of the Docker Hub (docker.io) cache
+	// +kubebuilder:validation:Optional
+	DockerHub string `json:"dockerhub,omitempty"`
+
+	// GHCR is the prefix of the GitHub Container Registry (ghcr.io) cache
+	// +kubebuilder:validation:Optional
+	GHCR string `json:"ghcr,omitempty"`
+
+	// Quay is the prefix of the Quay (quay.io) cache
+	// +kubebuilder:validation:Optional
+	Quay string `json:"quay,omitempty"`
+
+	// Kubernetes is the prefix of the Kubernetes registry
+	// (registry.k8s.io) cache
+	// +kubebuilder:validation:Optional
+	Kubernetes string `json:"k8s,omitempty"`
+
+	// ECRPublic is the prefix of the Amazon ECR Public (public.ecr.aws) cache
+	// +kubebuilder:validation:Optional
+	ECRPublic string `json:"ecrPublic,omitempty"`
+
+	// GitLab is the prefix of the GitLab Container Registry
+	// (registry.gitlab.com) cache
+	// +kubebuilder:validation:Optional
+	GitLab string `json:"gitlab,omitempty"`
+}
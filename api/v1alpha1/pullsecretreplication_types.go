@@ -0,0 +1,91 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PullSecretReplicationSpec defines the desired state of PullSecretReplication.
+type PullSecretReplicationSpec struct {
+	// SourceSecretRef references the dockerconfigjson Secret to replicate
+	// +kubebuilder:validation:Required
+	SourceSecretRef corev1.SecretReference `json:"sourceSecretRef"`
+
+	// NamespaceSelector selects the namespaces the Secret is replicated to.
+	// All namespaces are selected when empty.
+	// +kubebuilder:validation:Optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// TargetName is the name of the replicated Secrets. Defaults to the name
+	// of the source Secret.
+	// +kubebuilder:validation:Optional
+	TargetName string `json:"targetName,omitempty"`
+}
+
+// PullSecretReplicationStatus defines the observed state of PullSecretReplication.
+type PullSecretReplicationStatus struct {
+	// ObservedGeneration is the generation observed by the controller
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// ReplicatedNamespaces is the number of namespaces the Secret is
+	// replicated to
+	ReplicatedNamespaces int `json:"replicatedNamespaces,omitempty"`
+
+	// Conditions describe the state of the replication
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// Condition types of PullSecretReplication
+const (
+	// ConditionReplicated reports whether the source Secret is replicated to
+	// all the selected namespaces
+	ConditionReplicated = "Replicated"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster,shortName=psr
+// +kubebuilder:printcolumn:name="Namespaces",type="integer",JSONPath=".status.replicatedNamespaces",description="Number of namespaces the Secret is replicated to"
+// +kubebuilder:printcolumn:name="Replicated",type="string",JSONPath=".status.conditions[?(@.type==\"Replicated\")].status",description="Whether the Secret is replicated"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// PullSecretReplication is the Schema for the pullsecretreplications API.
+type PullSecretReplication struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PullSecretReplicationSpec   `json:"spec,omitempty"`
+	Status PullSecretReplicationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// PullSecretReplicationList contains a list of PullSecretReplication.
+type PullSecretReplicationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PullSecretReplication `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PullSecretReplication{}, &PullSecretReplicationList{})
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RegistryMirrorSpec defines the desired state of RegistryMirror.
+type RegistryMirrorSpec struct {
+	// Sources are the registry hosts, with their port, redirected to the
+	// target. Docker Hub is docker.io.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	Sources []string `json:"sources"`
+
+	// Target is the registry host, with its port, the images are redirected
+	// to
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Target string `json:"target"`
+
+	// PathPrefix is prepended to the repository of the redirected images
+	// +kubebuilder:validation:Optional
+	PathPrefix string `json:"pathPrefix,omitempty"`
+
+	// Priority orders the mirror among the rules of all RegistryRewriteRule
+	// and RegistryMirror resources (higher = more priority). At equal
+	// priority, RegistryRewriteRule rules are evaluated before mirrors.
+	// +kubebuilder:default=0
+	Priority int `json:"priority,omitempty"`
+
+	// Mode defines whether redirects are applied (enforce) or only recorded
+	// (audit). Defaults to enforce.
+	// +kubebuilder:validation:Optional
+	Mode RewriteMode `json:"mode,omitempty"`
+}
+
+// RegistryMirrorStatus defines the observed state of RegistryMirror.
+type RegistryMirrorStatus struct {
+	// ObservedGeneration is the generation observed by the controller
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// ServedPods is the number of pods whose images were redirected by the
+	// mirror. It is updated periodically.
+	ServedPods int64 `json:"servedPods,omitempty"`
+
+	// LastServedTime is the last time a pod was redirected by the mirror
+	LastServedTime *metav1.Time `json:"lastServedTime,omitempty"`
+
+	// Conditions describe the state of the mirror
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// Condition types of RegistryMirror and RegistryRewriteRule
+const (
+	// ConditionReady reports whether the resource is valid and used by the
+	// webhook
+	ConditionReady = "Ready"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster,shortName=rm
+// +kubebuilder:printcolumn:name="Target",type="string",JSONPath=".spec.target",description="Target registry"
+// +kubebuilder:printcolumn:name="Priority",type="integer",JSONPath=".spec.priority",description="Priority of the mirror"
+// +kubebuilder:printcolumn:name="Served",type="integer",JSONPath=".status.servedPods",description="Number of pods served"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description="Whether the mirror is used"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// RegistryMirror is the Schema for the registrymirrors API.
+type RegistryMirror struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RegistryMirrorSpec   `json:"spec,omitempty"`
+	Status RegistryMirrorStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// RegistryMirrorList contains a list of RegistryMirror.
+type RegistryMirrorList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RegistryMirror `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RegistryMirror{}, &RegistryMirrorList{})
+}
@@ -17,21 +17,45 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// RewriteMode defines whether a rewrite is applied to pods or only recorded
+// +kubebuilder:validation:Enum=enforce;audit
+type RewriteMode string
+
+const (
+	// RewriteModeEnforce rewrites the image of matching containers
+	RewriteModeEnforce RewriteMode = "enforce"
+	// RewriteModeAudit records the rewrite without changing the image
+	RewriteModeAudit RewriteMode = "audit"
+)
+
 // Rule defines a single registry rewrite rule
+// +kubebuilder:validation:XValidation:rule="has(self.replace) || has(self.tags) || has(self.mutableTagPolicy)",message="replace, tags or mutableTagPolicy must be set"
 type Rule struct {
 	// Match is a RE2 regular expression pattern to match against image names
 	// +kubebuilder:validation:Required
 	Match string `json:"match"`
 
-	// Replace is a Go text/template string using captured groups from the match
-	// +kubebuilder:validation:Required
-	Replace string `json:"replace"`
+	// Replace is a Go text/template string using captured groups from the match.
+	// When empty, rules rewriting tags or setting a mutable tag policy keep
+	// the matched image.
+	// +kubebuilder:validation:Optional
+	Replace string `json:"replace,omitempty"`
+
+	// Tags rewrites the tag of the matched images, after Replace
+	// +kubebuilder:validation:Optional
+	Tags *TagRewrite `json:"tags,omitempty"`
+
+	// MutableTagPolicy defines how the rule handles images whose rewritten
+	// reference uses the latest tag, or no tag. Defaults to allow.
+	// +kubebuilder:validation:Optional
+	MutableTagPolicy MutableTagPolicy `json:"mutableTagPolicy,omitempty"`
 
 	// Priority defines the order of rule evaluation (higher = more priority)
 	// +kubebuilder:validation:Optional
@@ -41,8 +65,174 @@ type Rule struct {
 	// Conditions specify when this rule should be applied
 	// +kubebuilder:validation:Optional
 	Conditions *RuleConditions `json:"conditions,omitempty"`
+
+	// Mode overrides the mode of the RegistryRewriteRule for this rule
+	// +kubebuilder:validation:Optional
+	Mode RewriteMode `json:"mode,omitempty"`
+
+	// AllowDigestChange allows the rule to change or remove the digest of
+	// images pinned by digest. Such rewrites are refused by default.
+	// +kubebuilder:validation:Optional
+	AllowDigestChange bool `json:"allowDigestChange,omitempty"`
+
+	// Verify checks at admission that the rewritten image exists in the
+	// target registry. When it doesn't, the original image is kept.
+	// +kubebuilder:validation:Optional
+	Verify bool `json:"verify,omitempty"`
+
+	// PinDigest resolves the tag of the rewritten image to its digest in the
+	// target registry, so that all replicas run identical bits even if the
+	// tag moves. The original tag is kept in an annotation.
+	// +kubebuilder:validation:Optional
+	PinDigest bool `json:"pinDigest,omitempty"`
+
+	// CredentialsSecretRef references a dockerconfigjson Secret holding the
+	// credentials of the target registry, used to verify images and pin
+	// digests
+	// +kubebuilder:validation:Optional
+	CredentialsSecretRef *corev1.SecretReference `json:"credentialsSecretRef,omitempty"`
+
+	// Targets lists the registries substituted to the registry of the
+	// rewritten image, chosen according to the target strategy
+	// +kubebuilder:validation:Optional
+	Targets []Target `json:"targets,omitempty"`
+
+	// TargetStrategy defines how a target is chosen. Defaults to failover.
+	// +kubebuilder:validation:Optional
+	TargetStrategy TargetStrategy `json:"targetStrategy,omitempty"`
+
+	// ImagePullSecrets are added to the pods whose images are rewritten by
+	// this rule, unless already present
+	// +kubebuilder:validation:Optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// RequireImagePullSecrets skips the rewrite when one of the image pull
+	// secrets of the rule doesn't exist in the namespace of the pod
+	// +kubebuilder:validation:Optional
+	RequireImagePullSecrets bool `json:"requireImagePullSecrets,omitempty"`
+
+	// PullPolicy overrides the image pull policy of the containers whose
+	// image is rewritten by this rule
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Always;IfNotPresent;Never
+	PullPolicy corev1.PullPolicy `json:"pullPolicy,omitempty"`
+
+	// EnvVars, when set, also rewrites the images held by the environment
+	// variables of the containers, such as the RELATED_IMAGE_* variables of
+	// operators. Variables set with valueFrom are left alone.
+	// +kubebuilder:validation:Optional
+	EnvVars *EnvVarRewrite `json:"envVars,omitempty"`
+
+	// TopologyKey is the node label matched against the topology values of
+	// the targets with the topology strategy. Defaults to
+	// topology.kubernetes.io/zone.
+	// +kubebuilder:validation:Optional
+	TopologyKey string `json:"topologyKey,omitempty"`
 }
 
+// TargetStrategy defines how the target of a rewrite is chosen
+// +kubebuilder:validation:Enum=failover;weighted;topology
+type TargetStrategy string
+
+const (
+	// TargetStrategyFailover uses the first healthy target, in order of
+	// preference, or the first one when none is healthy
+	TargetStrategyFailover TargetStrategy = "failover"
+	// TargetStrategyWeighted spreads workloads across the healthy targets
+	// according to their weights. All replicas of a workload use the same
+	// target.
+	TargetStrategyWeighted TargetStrategy = "weighted"
+	// TargetStrategyTopology uses the first healthy target serving the
+	// topology domain the pod is constrained to by its node selector or
+	// required node affinity. Targets without topology values are used when
+	// the domain can't be determined or has no healthy target.
+	TargetStrategyTopology TargetStrategy = "topology"
+)
+
+// Target is a registry images can be rewritten to
+type Target struct {
+	// Registry is the registry host, with its port
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Registry string `json:"registry"`
+
+	// Weight is the relative share of workloads using this target with the
+	// weighted strategy. Targets with a zero weight are only used when no
+	// available target has a weight.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=1
+	Weight int32 `json:"weight,omitempty"`
+
+	// TopologyValues are the values of the topology key, such as zones,
+	// served by this target with the topology strategy
+	// +kubebuilder:validation:Optional
+	TopologyValues []string `json:"topologyValues,omitempty"`
+
+	// Insecure probes the target, and looks up its images, over plain HTTP
+	// instead of HTTPS, such as an in-cluster pull-through cache served
+	// without TLS. The container runtime of the nodes must be configured to
+	// pull from it over HTTP too.
+	// +kubebuilder:validation:Optional
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+// EnvVarRewrite selects the environment variables whose values are rewritten
+// like container images
+type EnvVarRewrite struct {
+	// Names are RE2 regular expressions matched against the names of the
+	// variables. Defaults to ^RELATED_IMAGE_ and _IMAGE$.
+	// +kubebuilder:validation:Optional
+	Names []string `json:"names,omitempty"`
+}
+
+// TagRewrite rewrites the tag of images. Images pinned by digest are left
+// untouched.
+type TagRewrite struct {
+	// StripSuffixes lists suffixes removed from tags, such as -debug. The
+	// first matching suffix is removed, before the mapping is applied.
+	// +kubebuilder:validation:Optional
+	StripSuffixes []string `json:"stripSuffixes,omitempty"`
+
+	// Mapping maps tags to the tags they are rewritten to. Images without a
+	// tag are looked up as latest.
+	// +kubebuilder:validation:Optional
+	Mapping map[string]string `json:"mapping,omitempty"`
+
+	// ConfigMapRef references a ConfigMap whose data maps tags to the tags
+	// they are rewritten to. Its entries take precedence over Mapping.
+	// +kubebuilder:validation:Optional
+	ConfigMapRef *ConfigMapReference `json:"configMapRef,omitempty"`
+}
+
+// ConfigMapReference references a ConfigMap in a namespace
+type ConfigMapReference struct {
+	// Name is the name of the ConfigMap
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Namespace is the namespace of the ConfigMap
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Namespace string `json:"namespace"`
+}
+
+// MutableTagPolicy defines how images using a mutable tag are handled
+// +kubebuilder:validation:Enum=allow;warn;refuse
+type MutableTagPolicy string
+
+const (
+	// MutableTagPolicyAllow rewrites images regardless of their tag
+	MutableTagPolicyAllow MutableTagPolicy = "allow"
+	// MutableTagPolicyWarn rewrites images using a mutable tag with an
+	// admission warning
+	MutableTagPolicyWarn MutableTagPolicy = "warn"
+	// MutableTagPolicyRefuse keeps the original image when the rewritten
+	// image uses a mutable tag
+	MutableTagPolicyRefuse MutableTagPolicy = "refuse"
+)
+
 // RuleConditions defines conditions for when a rule should be applied
 type RuleConditions struct {
 	// Namespaces is a list of namespaces where this rule applies
@@ -52,14 +242,106 @@ type RuleConditions struct {
 	// Labels is a map of labels that must match for the rule to apply
 	// +kubebuilder:validation:Optional
 	Labels map[string]string `json:"labels,omitempty"`
+
+	// ImageSources restricts the rule to the images of the given sources.
+	// The rule applies to every source when empty.
+	// +kubebuilder:validation:Optional
+	ImageSources []ImageSource `json:"imageSources,omitempty"`
 }
 
+// ImageSource is a field holding images, of a pod or of a GitOps source
+// +kubebuilder:validation:Enum=container;initContainer;ephemeralContainer;imageVolume;ociArtifact
+type ImageSource string
+
+const (
+	// ImageSourceContainer is the image of a container, and its environment
+	// variables
+	ImageSourceContainer ImageSource = "container"
+	// ImageSourceInitContainer is the image of an init container, and its
+	// environment variables
+	ImageSourceInitContainer ImageSource = "initContainer"
+	// ImageSourceEphemeralContainer is the image of an ephemeral container,
+	// and its environment variables
+	ImageSourceEphemeralContainer ImageSource = "ephemeralContainer"
+	// ImageSourceImageVolume is the reference of an image volume
+	ImageSourceImageVolume ImageSource = "imageVolume"
+	// ImageSourceOCIArtifact is the reference of an OCI artifact of a Flux or
+	// Argo CD source
+	ImageSourceOCIArtifact ImageSource = "ociArtifact"
+)
+
 // RegistryRewriteRuleSpec defines the desired state of RegistryRewriteRule.
+// +kubebuilder:validation:XValidation:rule="has(self.rules) && size(self.rules) > 0 || has(self.preset)",message="rules or preset must be set"
 type RegistryRewriteRuleSpec struct {
 	// Rules is a list of registry rewrite rules
+	// +kubebuilder:validation:Optional
+	Rules []Rule `json:"rules,omitempty"`
+
+	// Preset generates rules for a well-known registry setup. They follow
+	// Rules, and are indexed after them.
+	// +kubebuilder:validation:Optional
+	Preset *Preset `json:"preset,omitempty"`
+
+	// Mode defines whether rewrites are applied (enforce) or only recorded
+	// (audit). Defaults to enforce.
+	// +kubebuilder:validation:Optional
+	Mode RewriteMode `json:"mode,omitempty"`
+
+	// Parameters define values referenced in the replace templates of the
+	// rules, such as {{ .accountId }}
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=name
+	Parameters []Parameter `json:"parameters,omitempty"`
+}
+
+// Parameter is a named value available to the replace templates of the rules
+// +kubebuilder:validation:XValidation:rule="has(self.value) != has(self.valueFrom)",message="exactly one of value or valueFrom must be set"
+type Parameter struct {
+	// Name is the name of the parameter in the templates
 	// +kubebuilder:validation:Required
-	// +kubebuilder:validation:MinItems=1
-	Rules []Rule `json:"rules"`
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z_][a-zA-Z0-9_]*$`
+	Name string `json:"name"`
+
+	// Value is the literal value of the parameter
+	// +kubebuilder:validation:Optional
+	Value string `json:"value,omitempty"`
+
+	// ValueFrom reads the value of the parameter from a ConfigMap or Secret
+	// key. The rules are resolved again when the object changes.
+	// +kubebuilder:validation:Optional
+	ValueFrom *ParameterSource `json:"valueFrom,omitempty"`
+}
+
+// ParameterSource is the source of the value of a parameter
+// +kubebuilder:validation:MinProperties=1
+// +kubebuilder:validation:MaxProperties=1
+type ParameterSource struct {
+	// ConfigMapKeyRef selects a key of a ConfigMap
+	// +kubebuilder:validation:Optional
+	ConfigMapKeyRef *KeyReference `json:"configMapKeyRef,omitempty"`
+
+	// SecretKeyRef selects a key of a Secret
+	// +kubebuilder:validation:Optional
+	SecretKeyRef *KeyReference `json:"secretKeyRef,omitempty"`
+}
+
+// KeyReference selects a key of a ConfigMap or Secret in a namespace
+type KeyReference struct {
+	// Name is the name of the object
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Namespace is the namespace of the object
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Namespace string `json:"namespace"`
+
+	// Key is the key of the value in the object data
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Key string `json:"key"`
 }
 
 // RegistryRewriteRuleStatus defines the observed state of RegistryRewriteRule.
@@ -75,8 +357,48 @@ type RegistryRewriteRuleStatus struct {
 
 	// LastUpdateTime is the last time the rules were updated
 	LastUpdateTime *metav1.Time `json:"lastUpdateTime,omitempty"`
+
+	// Warnings lists problems found when analyzing this resource against the
+	// global rule set, such as rules shadowed by a higher-priority rule or
+	// rules conflicting with another rule of the same priority
+	// +optional
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Rules reports the observed state of each rule
+	// +optional
+	// +listType=map
+	// +listMapKey=index
+	Rules []RuleStatus `json:"rules,omitempty"`
+
+	// Conditions describe the state of this resource, such as the health of
+	// the target registries of its rules
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// RuleStatus is the observed state of a rule
+type RuleStatus struct {
+	// Index is the index of the rule in spec.rules
+	Index int `json:"index"`
+
+	// PullFailures is the number of containers reverted to their original
+	// image after failing to pull the image rewritten by the rule
+	// +optional
+	PullFailures int64 `json:"pullFailures,omitempty"`
 }
 
+// Condition types of RegistryRewriteRule
+const (
+	// ConditionMirrorsHealthy reports whether the targets of the rules are
+	// healthy
+	ConditionMirrorsHealthy = "MirrorsHealthy"
+	// ConditionRulesSuspended reports whether rules are suspended by their
+	// circuit breaker because their rewritten images fail to pull
+	ConditionRulesSuspended = "RulesSuspended"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:scope=Cluster,shortName=rrr
@@ -21,9 +21,452 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ArtifactRegistryRemotePreset) DeepCopyInto(out *ArtifactRegistryRemotePreset) {
+	*out = *in
+	out.Repositories = in.Repositories
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArtifactRegistryRemotePreset.
+func (in *ArtifactRegistryRemotePreset) DeepCopy() *ArtifactRegistryRemotePreset {
+	if in == nil {
+		return nil
+	}
+	out := new(ArtifactRegistryRemotePreset)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ArtifactoryRemotePreset) DeepCopyInto(out *ArtifactoryRemotePreset) {
+	*out = *in
+	out.Repositories = in.Repositories
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArtifactoryRemotePreset.
+func (in *ArtifactoryRemotePreset) DeepCopy() *ArtifactoryRemotePreset {
+	if in == nil {
+		return nil
+	}
+	out := new(ArtifactoryRemotePreset)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConfigMapReference) DeepCopyInto(out *ConfigMapReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigMapReference.
+func (in *ConfigMapReference) DeepCopy() *ConfigMapReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ConfigMapReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ECRPullThroughCachePreset) DeepCopyInto(out *ECRPullThroughCachePreset) {
+	*out = *in
+	out.Upstreams = in.Upstreams
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ECRPullThroughCachePreset.
+func (in *ECRPullThroughCachePreset) DeepCopy() *ECRPullThroughCachePreset {
+	if in == nil {
+		return nil
+	}
+	out := new(ECRPullThroughCachePreset)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnvVarRewrite) DeepCopyInto(out *EnvVarRewrite) {
+	*out = *in
+	if in.Names != nil {
+		in, out := &in.Names, &out.Names
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvVarRewrite.
+func (in *EnvVarRewrite) DeepCopy() *EnvVarRewrite {
+	if in == nil {
+		return nil
+	}
+	out := new(EnvVarRewrite)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HarborProxyCachePreset) DeepCopyInto(out *HarborProxyCachePreset) {
+	*out = *in
+	out.Projects = in.Projects
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HarborProxyCachePreset.
+func (in *HarborProxyCachePreset) DeepCopy() *HarborProxyCachePreset {
+	if in == nil {
+		return nil
+	}
+	out := new(HarborProxyCachePreset)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyReference) DeepCopyInto(out *KeyReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyReference.
+func (in *KeyReference) DeepCopy() *KeyReference {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MirrorPreset) DeepCopyInto(out *MirrorPreset) {
+	*out = *in
+	if in.Upstreams != nil {
+		in, out := &in.Upstreams, &out.Upstreams
+		*out = make([]MirrorUpstream, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MirrorPreset.
+func (in *MirrorPreset) DeepCopy() *MirrorPreset {
+	if in == nil {
+		return nil
+	}
+	out := new(MirrorPreset)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MirrorUpstream) DeepCopyInto(out *MirrorUpstream) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MirrorUpstream.
+func (in *MirrorUpstream) DeepCopy() *MirrorUpstream {
+	if in == nil {
+		return nil
+	}
+	out := new(MirrorUpstream)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Parameter) DeepCopyInto(out *Parameter) {
+	*out = *in
+	if in.ValueFrom != nil {
+		in, out := &in.ValueFrom, &out.ValueFrom
+		*out = new(ParameterSource)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Parameter.
+func (in *Parameter) DeepCopy() *Parameter {
+	if in == nil {
+		return nil
+	}
+	out := new(Parameter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ParameterSource) DeepCopyInto(out *ParameterSource) {
+	*out = *in
+	if in.ConfigMapKeyRef != nil {
+		in, out := &in.ConfigMapKeyRef, &out.ConfigMapKeyRef
+		*out = new(KeyReference)
+		**out = **in
+	}
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(KeyReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ParameterSource.
+func (in *ParameterSource) DeepCopy() *ParameterSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ParameterSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Preset) DeepCopyInto(out *Preset) {
+	*out = *in
+	if in.ECRPullThroughCache != nil {
+		in, out := &in.ECRPullThroughCache, &out.ECRPullThroughCache
+		*out = new(ECRPullThroughCachePreset)
+		**out = **in
+	}
+	if in.HarborProxyCache != nil {
+		in, out := &in.HarborProxyCache, &out.HarborProxyCache
+		*out = new(HarborProxyCachePreset)
+		**out = **in
+	}
+	if in.ArtifactoryRemote != nil {
+		in, out := &in.ArtifactoryRemote, &out.ArtifactoryRemote
+		*out = new(ArtifactoryRemotePreset)
+		**out = **in
+	}
+	if in.ArtifactRegistryRemote != nil {
+		in, out := &in.ArtifactRegistryRemote, &out.ArtifactRegistryRemote
+		*out = new(ArtifactRegistryRemotePreset)
+		**out = **in
+	}
+	if in.Mirror != nil {
+		in, out := &in.Mirror, &out.Mirror
+		*out = new(MirrorPreset)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Preset.
+func (in *Preset) DeepCopy() *Preset {
+	if in == nil {
+		return nil
+	}
+	out := new(Preset)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PullSecretReplication) DeepCopyInto(out *PullSecretReplication) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PullSecretReplication.
+func (in *PullSecretReplication) DeepCopy() *PullSecretReplication {
+	if in == nil {
+		return nil
+	}
+	out := new(PullSecretReplication)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PullSecretReplication) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PullSecretReplicationList) DeepCopyInto(out *PullSecretReplicationList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]PullSecretReplication, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PullSecretReplicationList.
+func (in *PullSecretReplicationList) DeepCopy() *PullSecretReplicationList {
+	if in == nil {
+		return nil
+	}
+	out := new(PullSecretReplicationList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PullSecretReplicationList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PullSecretReplicationSpec) DeepCopyInto(out *PullSecretReplicationSpec) {
+	*out = *in
+	out.SourceSecretRef = in.SourceSecretRef
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PullSecretReplicationSpec.
+func (in *PullSecretReplicationSpec) DeepCopy() *PullSecretReplicationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PullSecretReplicationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PullSecretReplicationStatus) DeepCopyInto(out *PullSecretReplicationStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PullSecretReplicationStatus.
+func (in *PullSecretReplicationStatus) DeepCopy() *PullSecretReplicationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PullSecretReplicationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistryMirror) DeepCopyInto(out *RegistryMirror) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistryMirror.
+func (in *RegistryMirror) DeepCopy() *RegistryMirror {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistryMirror)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RegistryMirror) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistryMirrorList) DeepCopyInto(out *RegistryMirrorList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]RegistryMirror, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistryMirrorList.
+func (in *RegistryMirrorList) DeepCopy() *RegistryMirrorList {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistryMirrorList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RegistryMirrorList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistryMirrorSpec) DeepCopyInto(out *RegistryMirrorSpec) {
+	*out = *in
+	if in.Sources != nil {
+		in, out := &in.Sources, &out.Sources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistryMirrorSpec.
+func (in *RegistryMirrorSpec) DeepCopy() *RegistryMirrorSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistryMirrorSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistryMirrorStatus) DeepCopyInto(out *RegistryMirrorStatus) {
+	*out = *in
+	if in.LastServedTime != nil {
+		in, out := &in.LastServedTime, &out.LastServedTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistryMirrorStatus.
+func (in *RegistryMirrorStatus) DeepCopy() *RegistryMirrorStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistryMirrorStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RegistryRewriteRule) DeepCopyInto(out *RegistryRewriteRule) {
 	*out = *in
@@ -93,6 +536,18 @@ func (in *RegistryRewriteRuleSpec) DeepCopyInto(out *RegistryRewriteRuleSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Preset != nil {
+		in, out := &in.Preset, &out.Preset
+		*out = new(Preset)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Parameters != nil {
+		in, out := &in.Parameters, &out.Parameters
+		*out = make([]Parameter, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistryRewriteRuleSpec.
@@ -112,6 +567,23 @@ func (in *RegistryRewriteRuleStatus) DeepCopyInto(out *RegistryRewriteRuleStatus
 		in, out := &in.LastUpdateTime, &out.LastUpdateTime
 		*out = (*in).DeepCopy()
 	}
+	if in.Warnings != nil {
+		in, out := &in.Warnings, &out.Warnings
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]RuleStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistryRewriteRuleStatus.
@@ -127,11 +599,38 @@ func (in *RegistryRewriteRuleStatus) DeepCopy() *RegistryRewriteRuleStatus {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Rule) DeepCopyInto(out *Rule) {
 	*out = *in
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = new(TagRewrite)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = new(RuleConditions)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.CredentialsSecretRef != nil {
+		in, out := &in.CredentialsSecretRef, &out.CredentialsSecretRef
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]Target, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]v1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.EnvVars != nil {
+		in, out := &in.EnvVars, &out.EnvVars
+		*out = new(EnvVarRewrite)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Rule.
@@ -159,6 +658,11 @@ func (in *RuleConditions) DeepCopyInto(out *RuleConditions) {
 			(*out)[key] = val
 		}
 	}
+	if in.ImageSources != nil {
+		in, out := &in.ImageSources, &out.ImageSources
+		*out = make([]ImageSource, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RuleConditions.
@@ -170,3 +674,85 @@ func (in *RuleConditions) DeepCopy() *RuleConditions {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RuleStatus) DeepCopyInto(out *RuleStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RuleStatus.
+func (in *RuleStatus) DeepCopy() *RuleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RuleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TagRewrite) DeepCopyInto(out *TagRewrite) {
+	*out = *in
+	if in.StripSuffixes != nil {
+		in, out := &in.StripSuffixes, &out.StripSuffixes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Mapping != nil {
+		in, out := &in.Mapping, &out.Mapping
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ConfigMapRef != nil {
+		in, out := &in.ConfigMapRef, &out.ConfigMapRef
+		*out = new(ConfigMapReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TagRewrite.
+func (in *TagRewrite) DeepCopy() *TagRewrite {
+	if in == nil {
+		return nil
+	}
+	out := new(TagRewrite)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Target) DeepCopyInto(out *Target) {
+	*out = *in
+	if in.TopologyValues != nil {
+		in, out := &in.TopologyValues, &out.TopologyValues
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Target.
+func (in *Target) DeepCopy() *Target {
+	if in == nil {
+		return nil
+	}
+	out := new(Target)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Upstreams) DeepCopyInto(out *Upstreams) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Upstreams.
+func (in *Upstreams) DeepCopy() *Upstreams {
+	if in == nil {
+		return nil
+	}
+	out := new(Upstreams)
+	in.DeepCopyInto(out)
+	return out
+}
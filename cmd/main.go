@@ -21,16 +21,23 @@ import (
 	"flag"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
@@ -39,6 +46,9 @@ import (
 	admissionwebhook "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+	"github.com/flemzord/mutating-registry-webhook/internal/breaker"
+	"github.com/flemzord/mutating-registry-webhook/internal/controller"
+	"github.com/flemzord/mutating-registry-webhook/internal/registry"
 	webhookpkg "github.com/flemzord/mutating-registry-webhook/internal/webhook"
 	// +kubebuilder:scaffold:imports
 )
@@ -63,6 +73,23 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var conflictSampleImages string
+	var rewriteMode string
+	var admissionWarnings, auditAnnotations bool
+	var quietNamespaces string
+	var eventQPS float64
+	var eventBurst int
+	var eventInterval time.Duration
+	var pendingPodEvents int
+	var registryTimeout, registryCacheTTL, admissionTimeout time.Duration
+	var pinDigests bool
+	var mirrorProbeInterval time.Duration
+	var revertPullFailuresAfter time.Duration
+	var circuitBreaker bool
+	var circuitConfig breaker.Config
+	var replicatePullSecrets bool
+	var rewriteOCISources bool
+	var mirrorStatsInterval time.Duration
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -78,6 +105,51 @@ func main() {
 	flag.StringVar(&metricsCertKey, "metrics-cert-key", "tls.key", "The name of the metrics server key file.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&conflictSampleImages, "conflict-sample-images", "",
+		"Comma-separated list of images added to the built-in corpus used to detect shadowed and conflicting rules.")
+	flag.StringVar(&rewriteMode, "rewrite-mode", "",
+		"If set to enforce or audit, overrides the mode of every rule. In audit mode rewrites are only recorded.")
+	flag.BoolVar(&admissionWarnings, "admission-warnings", true,
+		"If set, admission warnings describing image rewrites are returned to clients.")
+	flag.BoolVar(&auditAnnotations, "audit-annotations", true,
+		"If set, audit annotations describing image rewrites are added to admission responses.")
+	flag.StringVar(&quietNamespaces, "quiet-namespaces", "",
+		"Comma-separated list of namespaces for which admission warnings and audit annotations are disabled.")
+	flag.Float64Var(&eventQPS, "event-qps", 5, "Maximum number of events per second emitted for rewritten pods.")
+	flag.IntVar(&eventBurst, "event-burst", 25, "Maximum burst of events emitted for rewritten pods.")
+	flag.DurationVar(&eventInterval, "event-interval", time.Minute,
+		"Minimum interval between two events of the same reason about the same object.")
+	flag.IntVar(&pendingPodEvents, "pending-pod-events", webhookpkg.DefaultPendingPodEvents,
+		"Maximum number of pods without owner waited for at once to emit their events.")
+	flag.DurationVar(&registryTimeout, "registry-timeout", registry.DefaultTimeout,
+		"Timeout of the requests sent to registries, for instance to verify rewritten images.")
+	flag.DurationVar(&registryCacheTTL, "registry-cache-ttl", registry.DefaultCacheTTL,
+		"Lifetime of cached registry lookups, including resolved digests.")
+	flag.DurationVar(&admissionTimeout, "admission-timeout", webhookpkg.DefaultAdmissionTimeout,
+		"Timeout of the handling of a pod, including the registry lookups of all its images. "+
+			"Keep it below the timeoutSeconds of the webhook configuration.")
+	flag.BoolVar(&pinDigests, "pin-digests", false,
+		"If set, the tags of all rewritten images are resolved to their digest in the target registry.")
+	flag.DurationVar(&mirrorProbeInterval, "mirror-probe-interval", webhookpkg.DefaultProbeInterval,
+		"Interval between two health probes of the target registries of the rules.")
+	flag.DurationVar(&revertPullFailuresAfter, "revert-pull-failures-after", 0,
+		"If set, rewritten containers failing to pull their image for this long are reverted to their original image.")
+	flag.BoolVar(&circuitBreaker, "circuit-breaker", false,
+		"If set, rules whose rewritten images fail to pull are suspended by a circuit breaker.")
+	flag.Float64Var(&circuitConfig.FailureRate, "circuit-failure-rate", breaker.DefaultFailureRate,
+		"Rate of failed pulls of the images rewritten by a rule past which the rule is suspended.")
+	flag.IntVar(&circuitConfig.MinRequests, "circuit-min-pulls", breaker.DefaultMinRequests,
+		"Minimum number of pulls within the window for a rule to be suspended.")
+	flag.DurationVar(&circuitConfig.Window, "circuit-window", breaker.DefaultWindow,
+		"Duration pulls are accounted for by the circuit breaker.")
+	flag.DurationVar(&circuitConfig.Cooldown, "circuit-cooldown", breaker.DefaultCooldown,
+		"Time a suspended rule waits before a trial rewrite.")
+	flag.DurationVar(&mirrorStatsInterval, "mirror-stats-interval", webhookpkg.DefaultMirrorStatsInterval,
+		"Interval between two updates of the served pods in the status of the RegistryMirror resources.")
+	flag.BoolVar(&replicatePullSecrets, "replicate-pull-secrets", false,
+		"If set, the Secrets of PullSecretReplication resources are replicated to the selected namespaces.")
+	flag.BoolVar(&rewriteOCISources, "rewrite-oci-sources", false,
+		"If set, the OCI artifact references of Flux and Argo CD sources are rewritten by the rules.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -86,6 +158,13 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	switch devv1alpha1.RewriteMode(rewriteMode) {
+	case "", devv1alpha1.RewriteModeEnforce, devv1alpha1.RewriteModeAudit:
+	default:
+		setupLog.Error(nil, "invalid rewrite mode, expected enforce or audit", "rewrite-mode", rewriteMode)
+		os.Exit(1)
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -180,6 +259,13 @@ func main() {
 		Metrics:                metricsServerOptions,
 		WebhookServer:          webhookServer,
 		HealthProbeBindAddress: probeAddr,
+		// Only cache the pods whose images were rewritten, which are the
+		// only ones reconciled by the controllers, and the replicated
+		// Secrets. Other Secrets are read through the API reader.
+		Cache: cache.Options{ByObject: map[client.Object]cache.ByObject{
+			&corev1.Pod{}:    {Label: labels.SelectorFromSet(labels.Set{webhookpkg.RewrittenLabel: "true"})},
+			&corev1.Secret{}: {Label: labels.SelectorFromSet(labels.Set{controller.ReplicatedSecretLabel: "true"})},
+		}},
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -190,9 +276,43 @@ func main() {
 	// the RulesWatcher to handle RegistryRewriteRule changes and invalidate the cache
 
 	// Setup the webhook
+	registryClient := &registry.Client{Timeout: registryTimeout, CacheTTL: registryCacheTTL}
+	mirrorProber := webhookpkg.NewMirrorProber(mgr.GetClient(), registryClient, mirrorProbeInterval)
+	if err := mgr.Add(mirrorProber); err != nil {
+		setupLog.Error(err, "unable to add mirror prober to manager")
+		os.Exit(1)
+	}
+	mirrorStats := webhookpkg.NewRegistryMirrorStats(mgr.GetClient(), mirrorStatsInterval)
+	if err := mgr.Add(mirrorStats); err != nil {
+		setupLog.Error(err, "unable to add registry mirror stats to manager")
+		os.Exit(1)
+	}
+	var circuits *breaker.Breaker
+	if circuitBreaker {
+		circuits = breaker.New(circuitConfig)
+	}
+	podEvents := webhookpkg.NewPodEventQueue(mgr.GetAPIReader(), pendingPodEvents)
+	if err := mgr.Add(podEvents); err != nil {
+		setupLog.Error(err, "unable to add pod event queue")
+		os.Exit(1)
+	}
 	podMutator := &webhookpkg.PodMutator{
-		Client: mgr.GetClient(),
+		Client:            mgr.GetClient(),
+		Mode:              devv1alpha1.RewriteMode(rewriteMode),
+		AdmissionWarnings: admissionWarnings,
+		AuditAnnotations:  auditAnnotations,
+		Recorder:          mgr.GetEventRecorder("registry-rewriter"),
+		EventLimiter:      webhookpkg.NewEventLimiter(float32(eventQPS), eventBurst, eventInterval),
+		PodEvents:         podEvents,
+		APIReader:         mgr.GetAPIReader(),
+		Registry:          registryClient,
+		AdmissionTimeout:  admissionTimeout,
+		PinDigests:        pinDigests,
+		Mirrors:           mirrorProber,
+		Breaker:           circuits,
+		MirrorStats:       mirrorStats,
 	}
+	podMutator.QuietNamespaces = splitList(quietNamespaces)
 
 	// Inject the decoder
 	decoder := admissionwebhook.NewDecoder(scheme)
@@ -205,17 +325,59 @@ func main() {
 	mgr.GetWebhookServer().Register("/mutate-v1-pod", &admissionwebhook.Webhook{
 		Handler: podMutator,
 	})
+	// The OCI sources webhook is always configured, so its handler is always
+	// registered and only rewrites when enabled
+	mgr.GetWebhookServer().Register("/mutate-oci-sources", &admissionwebhook.Webhook{
+		Handler: &webhookpkg.OCISourceMutator{Mutator: podMutator, Disabled: !rewriteOCISources},
+	})
 
 	// Setup the rules watcher
-	if err := ctrl.NewControllerManagedBy(mgr).
-		For(&devv1alpha1.RegistryRewriteRule{}).
-		Complete(&webhookpkg.RulesWatcher{
-			Client:  mgr.GetClient(),
-			Mutator: podMutator,
-		}); err != nil {
+	sampleImages := append(slices.Clone(webhookpkg.DefaultSampleImages), splitList(conflictSampleImages)...)
+	if err := (&webhookpkg.RulesWatcher{
+		Client:       mgr.GetClient(),
+		Mutator:      podMutator,
+		SampleImages: sampleImages,
+		Prober:       mirrorProber,
+		Breaker:      circuits,
+	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create rules watcher")
 		os.Exit(1)
 	}
+	if err := (&webhookpkg.RegistryMirrorWatcher{
+		Client:  mgr.GetClient(),
+		Mutator: podMutator,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create registry mirror watcher")
+		os.Exit(1)
+	}
+	if revertPullFailuresAfter > 0 {
+		if err := (&controller.PullFailureReconciler{
+			Client:    mgr.GetClient(),
+			Recorder:  mgr.GetEventRecorder("registry-rewriter"),
+			Threshold: revertPullFailuresAfter,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "PullFailure")
+			os.Exit(1)
+		}
+	}
+	if circuits != nil {
+		if err := (&controller.CircuitBreakerReconciler{
+			Client:  mgr.GetClient(),
+			Breaker: circuits,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "CircuitBreaker")
+			os.Exit(1)
+		}
+	}
+	if replicatePullSecrets {
+		if err := (&controller.PullSecretReplicationReconciler{
+			Client:    mgr.GetClient(),
+			APIReader: mgr.GetAPIReader(),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "PullSecretReplication")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if metricsCertWatcher != nil {
@@ -249,3 +411,15 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// splitList splits a comma-separated flag value, trimming spaces and dropping
+// empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package breaker implements circuit breakers suspending rewrite rules whose
+// rewritten images fail to pull.
+package breaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// State is the state of a circuit
+type State string
+
+const (
+	// StateClosed lets requests through while observing their outcome
+	StateClosed State = "closed"
+	// StateOpen rejects requests until the cooldown elapses
+	StateOpen State = "open"
+	// StateHalfOpen lets a trial request through, whose outcome closes or
+	// reopens the circuit
+	StateHalfOpen State = "half-open"
+)
+
+// Defaults of Config
+const (
+	DefaultFailureRate = 0.5
+	DefaultMinRequests = 5
+	DefaultWindow      = 5 * time.Minute
+	DefaultCooldown    = time.Minute
+)
+
+// stateValues are the values of the circuit state gauge
+var stateValues = map[State]float64{StateClosed: 0, StateOpen: 1, StateHalfOpen: 2}
+
+var circuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "registry_rewriter_circuit_state",
+	Help: "State of the circuit breaker of a rule: 0 closed, 1 open, 2 half-open",
+}, []string{"circuit"})
+
+func init() {
+	metrics.Registry.MustRegister(circuitState)
+}
+
+// Config configures a Breaker. Zero values are replaced by the defaults.
+type Config struct {
+	// FailureRate is the rate of failures within the window past which a
+	// circuit opens
+	FailureRate float64
+	// MinRequests is the minimum number of outcomes within the window for a
+	// circuit to open
+	MinRequests int
+	// Window is the duration outcomes are accounted for
+	Window time.Duration
+	// Cooldown is the time an open circuit waits before letting a trial
+	// request through, and the time a trial waits for its outcome
+	Cooldown time.Duration
+}
+
+// outcome is the outcome of a request
+type outcome struct {
+	time    time.Time
+	failure bool
+}
+
+// circuit is the state of the circuit of a key
+type circuit struct {
+	state    State
+	openedAt time.Time
+	trialAt  time.Time
+	outcomes []outcome
+}
+
+// Breaker tracks the circuits of a set of keys, such as rules. It is safe for
+// concurrent use.
+type Breaker struct {
+	config Config
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+	changes  chan struct{}
+	// now returns the current time, overridden in tests
+	now func() time.Time
+}
+
+// New returns a Breaker with all circuits closed
+func New(config Config) *Breaker {
+	if config.FailureRate == 0 {
+		config.FailureRate = DefaultFailureRate
+	}
+	if config.MinRequests == 0 {
+		config.MinRequests = DefaultMinRequests
+	}
+	if config.Window == 0 {
+		config.Window = DefaultWindow
+	}
+	if config.Cooldown == 0 {
+		config.Cooldown = DefaultCooldown
+	}
+	return &Breaker{
+		config:   config,
+		circuits: map[string]*circuit{},
+		changes:  make(chan struct{}, 1),
+		now:      time.Now,
+	}
+}
+
+// Allow reports whether a request for key may go through. An open circuit
+// turns half-open once the cooldown elapsed and lets one trial request
+// through per cooldown. A nil Breaker allows everything.
+func (b *Breaker) Allow(key string) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[key]
+	if !ok {
+		return true
+	}
+
+	now := b.now()
+	switch c.state {
+	case StateOpen:
+		if now.Sub(c.openedAt) < b.config.Cooldown {
+			return false
+		}
+		b.transition(key, c, StateHalfOpen)
+		c.trialAt = now
+		return true
+	case StateHalfOpen:
+		if now.Sub(c.trialAt) < b.config.Cooldown {
+			return false
+		}
+		c.trialAt = now
+		return true
+	default:
+		return true
+	}
+}
+
+// Record records the outcome of a request for key. Outcomes of requests let
+// through before the circuit opened are ignored while it is open.
+func (b *Breaker) Record(key string, failure bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[key]
+	if !ok {
+		c = &circuit{state: StateClosed}
+		b.circuits[key] = c
+	}
+
+	now := b.now()
+	switch c.state {
+	case StateOpen:
+		return
+	case StateHalfOpen:
+		if failure {
+			b.transition(key, c, StateOpen)
+			c.openedAt = now
+		} else {
+			b.transition(key, c, StateClosed)
+			c.outcomes = nil
+		}
+		return
+	}
+
+	// Forget the outcomes out of the window
+	i := 0
+	for i < len(c.outcomes) && now.Sub(c.outcomes[i].time) > b.config.Window {
+		i++
+	}
+	c.outcomes = append(c.outcomes[i:], outcome{time: now, failure: failure})
+
+	failures := 0
+	for _, o := range c.outcomes {
+		if o.failure {
+			failures++
+		}
+	}
+	if len(c.outcomes) >= b.config.MinRequests &&
+		float64(failures)/float64(len(c.outcomes)) >= b.config.FailureRate {
+		b.transition(key, c, StateOpen)
+		c.openedAt = now
+		c.outcomes = nil
+	}
+}
+
+// State returns the state of the circuit of key
+func (b *Breaker) State(key string) State {
+	if b == nil {
+		return StateClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[key]
+	if !ok {
+		return StateClosed
+	}
+	if c.state == StateOpen && b.now().Sub(c.openedAt) >= b.config.Cooldown {
+		return StateHalfOpen
+	}
+	return c.state
+}
+
+// Cooldown returns the time an open circuit waits before turning half-open
+func (b *Breaker) Cooldown() time.Duration {
+	return b.config.Cooldown
+}
+
+// Changes returns a channel receiving a value whenever a circuit changes
+// state. Changes are coalesced when the channel isn't drained.
+func (b *Breaker) Changes() <-chan struct{} {
+	return b.changes
+}
+
+// transition changes the state of a circuit and reports the change
+func (b *Breaker) transition(key string, c *circuit, state State) {
+	if c.state == state {
+		return
+	}
+	c.state = state
+	circuitState.WithLabelValues(key).Set(stateValues[state])
+	select {
+	case b.changes <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestBreaker returns a breaker whose clock is advanced by the returned
+// function
+func newTestBreaker(config Config) (*Breaker, func(time.Duration)) {
+	b := New(config)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+	return b, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestBreakerOpens(t *testing.T) {
+	b, _ := newTestBreaker(Config{FailureRate: 0.5, MinRequests: 4})
+
+	b.Record("rule", true)
+	b.Record("rule", true)
+	b.Record("rule", true)
+	if state := b.State("rule"); state != StateClosed {
+		t.Fatalf("state after 3 outcomes = %s, want %s", state, StateClosed)
+	}
+	b.Record("rule", false)
+	if state := b.State("rule"); state != StateOpen {
+		t.Fatalf("state after 4 outcomes = %s, want %s", state, StateOpen)
+	}
+	if b.Allow("rule") {
+		t.Error("open circuit allowed a request")
+	}
+	if !b.Allow("other") {
+		t.Error("unknown circuit rejected a request")
+	}
+	select {
+	case <-b.Changes():
+	default:
+		t.Error("opening the circuit was not notified")
+	}
+}
+
+func TestBreakerWindow(t *testing.T) {
+	b, advance := newTestBreaker(Config{FailureRate: 0.5, MinRequests: 2, Window: time.Minute})
+
+	b.Record("rule", true)
+	advance(2 * time.Minute)
+	b.Record("rule", false)
+	b.Record("rule", false)
+	if state := b.State("rule"); state != StateClosed {
+		t.Errorf("state = %s, want %s: the failure out of the window must be ignored", state, StateClosed)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	b, advance := newTestBreaker(Config{FailureRate: 1, MinRequests: 1, Cooldown: time.Minute})
+
+	b.Record("rule", true)
+	advance(time.Minute)
+	if state := b.State("rule"); state != StateHalfOpen {
+		t.Fatalf("state after cooldown = %s, want %s", state, StateHalfOpen)
+	}
+	if !b.Allow("rule") {
+		t.Fatal("half-open circuit rejected the trial request")
+	}
+	if b.Allow("rule") {
+		t.Error("half-open circuit allowed a second request before the trial outcome")
+	}
+
+	b.Record("rule", true)
+	if state := b.State("rule"); state != StateOpen {
+		t.Fatalf("state after a failed trial = %s, want %s", state, StateOpen)
+	}
+
+	advance(time.Minute)
+	if !b.Allow("rule") {
+		t.Fatal("half-open circuit rejected the trial request")
+	}
+	b.Record("rule", false)
+	if state := b.State("rule"); state != StateClosed {
+		t.Errorf("state after a successful trial = %s, want %s", state, StateClosed)
+	}
+	if !b.Allow("rule") {
+		t.Error("closed circuit rejected a request")
+	}
+}
+
+func TestNilBreaker(t *testing.T) {
+	var b *Breaker
+	b.Record("rule", true)
+	if !b.Allow("rule") || b.State("rule") != StateClosed {
+		t.Error("nil breaker must allow everything")
+	}
+}
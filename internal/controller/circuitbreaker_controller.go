@@ -0,0 +1,133 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/flemzord/mutating-registry-webhook/internal/breaker"
+	"github.com/flemzord/mutating-registry-webhook/internal/webhook"
+)
+
+// observedPulls tracks the containers of a pod whose pull outcome was
+// recorded
+type observedPulls struct {
+	uid        types.UID
+	containers map[string]bool
+}
+
+// CircuitBreakerReconciler records the outcome of the pulls of rewritten
+// images in the circuit breaker of the rule that rewrote them. It runs on
+// every replica, since each one feeds the breaker consulted by its webhook.
+type CircuitBreakerReconciler struct {
+	client.Client
+	Breaker *breaker.Breaker
+
+	mu       sync.Mutex
+	observed map[types.NamespacedName]observedPulls
+}
+
+// Reconcile records the pull outcome of the rewritten containers of a pod,
+// once per container
+func (r *CircuitBreakerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	pod := &corev1.Pod{}
+	if err := r.Get(ctx, req.NamespacedName, pod); err != nil {
+		r.forget(req.NamespacedName)
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	records, err := webhook.ParseOriginalImages(pod.Annotations)
+	if err != nil {
+		return ctrl.Result{}, nil
+	}
+
+	images := map[string]string{}
+	for _, c := range slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers) {
+		images[c.Name] = c.Image
+	}
+	for _, status := range slices.Concat(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses) {
+		record, ok := records[status.Name]
+		if !ok || images[status.Name] != record.Rewritten {
+			continue
+		}
+
+		failure := status.State.Waiting != nil && pullFailureReasons[status.State.Waiting.Reason]
+		if !failure && status.ImageID == "" {
+			// The image is still being pulled
+			continue
+		}
+		if !r.observe(pod, status.Name) {
+			continue
+		}
+		log.V(1).Info("Recorded image pull outcome", "pod", req.NamespacedName, "container", status.Name,
+			"rule", record.RuleRef(), "failure", failure)
+		r.Breaker.Record(record.RuleRef(), failure)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// observe marks the pull outcome of a container as recorded, and reports
+// whether it wasn't already
+func (r *CircuitBreakerReconciler) observe(pod *corev1.Pod, container string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.observed == nil {
+		r.observed = map[types.NamespacedName]observedPulls{}
+	}
+
+	key := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+	observed, ok := r.observed[key]
+	if !ok || observed.uid != pod.UID {
+		observed = observedPulls{uid: pod.UID, containers: map[string]bool{}}
+		r.observed[key] = observed
+	}
+	if observed.containers[container] {
+		return false
+	}
+	observed.containers[container] = true
+	return true
+}
+
+// forget stops tracking the containers of a pod
+func (r *CircuitBreakerReconciler) forget(key types.NamespacedName) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.observed, key)
+}
+
+// SetupWithManager sets up the controller with the Manager. Only pods with
+// rewritten images are reconciled.
+func (r *CircuitBreakerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	needLeaderElection := false
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Pod{}, builder.WithPredicates(hasRewrittenImages)).
+		WithOptions(controller.Options{NeedLeaderElection: &needLeaderElection}).
+		Named("circuitbreaker").
+		Complete(r)
+}
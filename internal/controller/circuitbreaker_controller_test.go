@@ -0,0 +1,103 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/flemzord/mutating-registry-webhook/internal/breaker"
+	"github.com/flemzord/mutating-registry-webhook/internal/webhook"
+)
+
+var _ = Describe("CircuitBreaker Controller", func() {
+	const rewritten = "mirror.local/library/nginx:1.25"
+
+	newPod := func(name string, status corev1.ContainerStatus) *corev1.Pod {
+		status.Name = "app"
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				UID:       types.UID(name + "-uid"),
+				Annotations: map[string]string{
+					webhook.OriginalImagesAnnotation: `{"app":{"original":"nginx:1.25","rewritten":"` + rewritten +
+						`","rule":"mirror","index":0}}`,
+				},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: rewritten}}},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{status},
+			},
+		}
+	}
+
+	failing := corev1.ContainerStatus{
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+	}
+	pulled := corev1.ContainerStatus{
+		ImageID: "docker-pullable://" + rewritten,
+		State:   corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+	}
+	pulling := corev1.ContainerStatus{
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+	}
+
+	reconcilePods := func(circuits *breaker.Breaker, pods ...*corev1.Pod) {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		builder := fake.NewClientBuilder().WithScheme(scheme)
+		for _, pod := range pods {
+			builder = builder.WithObjects(pod)
+		}
+		reconciler := &CircuitBreakerReconciler{Client: builder.Build(), Breaker: circuits}
+
+		for range 2 {
+			for _, pod := range pods {
+				key := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+				_, err := reconciler.Reconcile(context.Background(), reconcile.Request{NamespacedName: key})
+				Expect(err).NotTo(HaveOccurred())
+			}
+		}
+	}
+
+	It("should open the circuit of a rule whose images fail to pull", func() {
+		circuits := breaker.New(breaker.Config{FailureRate: 0.5, MinRequests: 2})
+		reconcilePods(circuits, newPod("a", failing), newPod("b", failing), newPod("c", pulled))
+		Expect(circuits.State("mirror/0")).To(Equal(breaker.StateOpen))
+	})
+
+	It("should record each pull outcome once", func() {
+		circuits := breaker.New(breaker.Config{FailureRate: 0.5, MinRequests: 2})
+		reconcilePods(circuits, newPod("a", failing))
+		Expect(circuits.State("mirror/0")).To(Equal(breaker.StateClosed))
+	})
+
+	It("should ignore images being pulled and successful pulls", func() {
+		circuits := breaker.New(breaker.Config{FailureRate: 0.5, MinRequests: 2})
+		reconcilePods(circuits, newPod("a", pulling), newPod("b", pulled), newPod("c", pulled), newPod("d", failing))
+		Expect(circuits.State("mirror/0")).To(Equal(breaker.StateClosed))
+	})
+})
@@ -0,0 +1,299 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+	"github.com/flemzord/mutating-registry-webhook/internal/webhook"
+)
+
+// DefaultPullFailureThreshold is the default time a container may stay in
+// pull backoff before its image is reverted
+const DefaultPullFailureThreshold = 5 * time.Minute
+
+// ReasonImageReverted is the reason of events describing containers reverted
+// to their original image
+const ReasonImageReverted = "ImageReverted"
+
+// pullFailureReasons are the waiting reasons of containers failing to pull
+// their image
+var pullFailureReasons = map[string]bool{
+	"ErrImagePull":     true,
+	"ImagePullBackOff": true,
+}
+
+// hasRewrittenImages selects the pods whose images were rewritten by the
+// webhook. The manager cache is expected to only hold the pods carrying the
+// webhook.RewrittenLabel.
+var hasRewrittenImages = predicate.NewPredicateFuncs(func(obj client.Object) bool {
+	_, ok := obj.GetAnnotations()[webhook.OriginalImagesAnnotation]
+	return ok
+})
+
+var imageReverts = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "registry_rewriter_image_reverts_total",
+	Help: "Total number of containers reverted to their original image after failing to pull the rewritten image",
+}, []string{"rule"})
+
+func init() {
+	metrics.Registry.MustRegister(imageReverts)
+}
+
+// pullFailures tracks the containers of a pod failing to pull their image
+type pullFailures struct {
+	uid types.UID
+	// since is the time each failing container was first seen failing
+	since map[string]time.Time
+}
+
+// PullFailureReconciler reverts the containers of rewritten pods stuck in
+// pull backoff to their original image, and disables the rewrite of the pod
+type PullFailureReconciler struct {
+	client.Client
+	Recorder events.EventRecorder
+	// Threshold is the time a container may stay in pull backoff before its
+	// image is reverted. DefaultPullFailureThreshold is used when zero.
+	Threshold time.Duration
+
+	mu       sync.Mutex
+	failures map[types.NamespacedName]pullFailures
+}
+
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
+
+// Reconcile reverts the containers of a pod that failed to pull their
+// rewritten image for longer than the threshold
+func (r *PullFailureReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	pod := &corev1.Pod{}
+	if err := r.Get(ctx, req.NamespacedName, pod); err != nil {
+		r.forget(req.NamespacedName)
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	records, err := webhook.ParseOriginalImages(pod.Annotations)
+	if err != nil || len(records) == 0 || !pod.DeletionTimestamp.IsZero() ||
+		pod.Annotations[webhook.RewriteDisabledAnnotation] == "true" {
+		r.forget(req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+
+	now := time.Now()
+	threshold := r.threshold()
+	var reverted []string
+	var requeueAfter time.Duration
+	for container, since := range r.track(pod, failingContainers(pod, records), now) {
+		if elapsed := now.Sub(since); elapsed < threshold {
+			if remaining := threshold - elapsed; requeueAfter == 0 || remaining < requeueAfter {
+				requeueAfter = remaining
+			}
+			continue
+		}
+		reverted = append(reverted, container)
+	}
+	if len(reverted) == 0 {
+		return ctrl.Result{RequeueAfter: requeueAfter}, nil
+	}
+
+	if err := r.revert(ctx, pod, records, reverted); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.forget(req.NamespacedName)
+
+	for _, container := range reverted {
+		record := records[container]
+		log.Info("Reverted container to its original image", "pod", req.NamespacedName, "container", container,
+			"image", record.Original, "rewrittenImage", record.Rewritten, "rule", record.Rule, "index", record.Index)
+		imageReverts.WithLabelValues(record.RuleRef()).Inc()
+		if r.Recorder != nil {
+			r.Recorder.Eventf(pod, nil, corev1.EventTypeWarning, ReasonImageReverted, "Revert",
+				"Reverted container %s to image %s after failing to pull %s for %s",
+				container, record.Original, record.Rewritten, threshold)
+		}
+		if err := r.recordPullFailure(ctx, record); err != nil {
+			log.Error(err, "Failed to record pull failure", "rule", record.Rule, "index", record.Index)
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// failingContainers returns the rewritten containers of a pod failing to pull
+// their rewritten image
+func failingContainers(pod *corev1.Pod, records map[string]webhook.ImageRecord) []string {
+	images := map[string]string{}
+	for _, c := range slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers) {
+		images[c.Name] = c.Image
+	}
+
+	var failing []string
+	for _, status := range slices.Concat(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses) {
+		record, ok := records[status.Name]
+		if !ok || images[status.Name] != record.Rewritten {
+			continue
+		}
+		if status.State.Waiting != nil && pullFailureReasons[status.State.Waiting.Reason] {
+			failing = append(failing, status.Name)
+		}
+	}
+	return failing
+}
+
+// track records the containers of a pod currently failing, and returns the
+// time each one was first seen failing
+func (r *PullFailureReconciler) track(pod *corev1.Pod, failing []string, now time.Time) map[string]time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures == nil {
+		r.failures = map[types.NamespacedName]pullFailures{}
+	}
+
+	key := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+	previous := r.failures[key]
+	if previous.uid != pod.UID {
+		previous = pullFailures{}
+	}
+	current := pullFailures{uid: pod.UID, since: map[string]time.Time{}}
+	for _, container := range failing {
+		since, ok := previous.since[container]
+		if !ok {
+			since = now
+		}
+		current.since[container] = since
+	}
+
+	if len(failing) == 0 {
+		delete(r.failures, key)
+	} else {
+		r.failures[key] = current
+	}
+	return current.since
+}
+
+// forget stops tracking the failures of a pod
+func (r *PullFailureReconciler) forget(key types.NamespacedName) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.failures, key)
+}
+
+// revert patches the containers of a pod back to their original image and
+// disables the rewrite of the pod, so that the webhook doesn't rewrite them
+// again on update
+func (r *PullFailureReconciler) revert(
+	ctx context.Context, pod *corev1.Pod, records map[string]webhook.ImageRecord, containers []string,
+) error {
+	patch := client.MergeFromWithOptions(pod.DeepCopy(), client.MergeFromWithOptimisticLock{})
+
+	remaining := map[string]webhook.ImageRecord{}
+	for name, record := range records {
+		remaining[name] = record
+	}
+	for _, name := range containers {
+		for i := range pod.Spec.InitContainers {
+			if pod.Spec.InitContainers[i].Name == name {
+				pod.Spec.InitContainers[i].Image = records[name].Original
+			}
+		}
+		for i := range pod.Spec.Containers {
+			if pod.Spec.Containers[i].Name == name {
+				pod.Spec.Containers[i].Image = records[name].Original
+			}
+		}
+		delete(remaining, name)
+	}
+
+	value, err := json.Marshal(remaining)
+	if err != nil {
+		return err
+	}
+	pod.Annotations[webhook.OriginalImagesAnnotation] = string(value)
+	pod.Annotations[webhook.RewriteDisabledAnnotation] = "true"
+
+	if err := r.Patch(ctx, pod, patch); err != nil {
+		return fmt.Errorf("failed to revert images of pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+	return nil
+}
+
+// recordPullFailure increments the pull failure counter of the rule that
+// rewrote a reverted container. Failures of mirrors are only counted by the
+// metric.
+func (r *PullFailureReconciler) recordPullFailure(ctx context.Context, record webhook.ImageRecord) error {
+	if record.Mirror {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		rule := &devv1alpha1.RegistryRewriteRule{}
+		if err := r.Get(ctx, types.NamespacedName{Name: record.Rule}, rule); err != nil {
+			if errors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+
+		found := false
+		for i := range rule.Status.Rules {
+			if rule.Status.Rules[i].Index == record.Index {
+				rule.Status.Rules[i].PullFailures++
+				found = true
+			}
+		}
+		if !found {
+			rule.Status.Rules = append(rule.Status.Rules, devv1alpha1.RuleStatus{Index: record.Index, PullFailures: 1})
+		}
+		return r.Status().Update(ctx, rule)
+	})
+}
+
+// threshold returns the time a container may stay in pull backoff
+func (r *PullFailureReconciler) threshold() time.Duration {
+	if r.Threshold != 0 {
+		return r.Threshold
+	}
+	return DefaultPullFailureThreshold
+}
+
+// SetupWithManager sets up the controller with the Manager. Only pods with
+// rewritten images are reconciled.
+func (r *PullFailureReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Pod{}, builder.WithPredicates(hasRewrittenImages)).
+		Named("pullfailure").
+		Complete(r)
+}
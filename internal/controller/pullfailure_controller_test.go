@@ -0,0 +1,147 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+	"github.com/flemzord/mutating-registry-webhook/internal/webhook"
+)
+
+var _ = Describe("PullFailure Controller", func() {
+	const rewritten = "mirror.local/library/nginx:1.25"
+
+	var (
+		ctx        context.Context
+		c          client.Client
+		recorder   *events.FakeRecorder
+		reconciler *PullFailureReconciler
+		key        types.NamespacedName
+	)
+
+	newPod := func(reason string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web",
+				Namespace: "default",
+				UID:       "pod-uid",
+				Annotations: map[string]string{
+					webhook.OriginalImagesAnnotation: `{"app":{"original":"nginx:1.25","rewritten":"` + rewritten +
+						`","rule":"mirror","index":0}}`,
+				},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Image: rewritten}, {Name: "sidecar", Image: "envoy:1.29"}},
+			},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "app",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}},
+				}},
+			},
+		}
+	}
+
+	setup := func(pod *corev1.Pod, threshold time.Duration) {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(devv1alpha1.AddToScheme(scheme)).To(Succeed())
+		c = fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(pod, &devv1alpha1.RegistryRewriteRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "mirror"},
+				Spec: devv1alpha1.RegistryRewriteRuleSpec{
+					Rules: []devv1alpha1.Rule{{Match: `^docker\.io/(.*)`, Replace: `mirror.local/$1`}},
+				},
+			}).
+			WithStatusSubresource(&devv1alpha1.RegistryRewriteRule{}).
+			Build()
+		recorder = events.NewFakeRecorder(10)
+		reconciler = &PullFailureReconciler{Client: c, Recorder: recorder, Threshold: threshold}
+		key = types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	It("should wait for the threshold before reverting", func() {
+		setup(newPod("ImagePullBackOff"), time.Hour)
+
+		result, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeNumerically("~", time.Hour, time.Minute))
+
+		pod := &corev1.Pod{}
+		Expect(c.Get(ctx, key, pod)).To(Succeed())
+		Expect(pod.Spec.Containers[0].Image).To(Equal(rewritten))
+	})
+
+	It("should revert containers stuck in pull backoff past the threshold", func() {
+		setup(newPod("ErrImagePull"), time.Millisecond)
+
+		_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		time.Sleep(2 * time.Millisecond)
+		_, err = reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		pod := &corev1.Pod{}
+		Expect(c.Get(ctx, key, pod)).To(Succeed())
+		Expect(pod.Spec.Containers[0].Image).To(Equal("nginx:1.25"))
+		Expect(pod.Spec.Containers[1].Image).To(Equal("envoy:1.29"))
+		Expect(pod.Annotations).To(HaveKeyWithValue(webhook.RewriteDisabledAnnotation, "true"))
+		records, err := webhook.ParseOriginalImages(pod.Annotations)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(BeEmpty())
+
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning ImageReverted Reverted container app to image nginx:1.25")))
+
+		rule := &devv1alpha1.RegistryRewriteRule{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "mirror"}, rule)).To(Succeed())
+		Expect(rule.Status.Rules).To(ConsistOf(devv1alpha1.RuleStatus{Index: 0, PullFailures: 1}))
+	})
+
+	It("should not revert containers pulling their image", func() {
+		setup(newPod("ContainerCreating"), time.Millisecond)
+
+		for range 2 {
+			result, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeZero())
+			time.Sleep(2 * time.Millisecond)
+		}
+
+		pod := &corev1.Pod{}
+		Expect(c.Get(ctx, key, pod)).To(Succeed())
+		Expect(pod.Spec.Containers[0].Image).To(Equal(rewritten))
+		Expect(recorder.Events).NotTo(Receive())
+	})
+})
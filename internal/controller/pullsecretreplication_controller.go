@@ -0,0 +1,271 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+// ReplicatedSecretLabel marks the Secrets copied by a PullSecretReplication,
+// which is also their controller. The manager only caches the Secrets with
+// this label.
+const ReplicatedSecretLabel = "dev.flemzord.fr/replicated-secret"
+
+// errSecretConflict is returned when a Secret with the name of a copy exists
+// but isn't managed by the replication
+var errSecretConflict = errors.New("secret not managed by the replication")
+
+// PullSecretReplicationReconciler copies the source Secret of a
+// PullSecretReplication into the selected namespaces, keeps the copies in
+// sync and deletes the copies of namespaces no longer selected
+type PullSecretReplicationReconciler struct {
+	client.Client
+	// APIReader reads the source Secrets, which aren't cached. Client is used
+	// when nil.
+	APIReader client.Reader
+}
+
+// +kubebuilder:rbac:groups=dev.flemzord.fr,resources=pullsecretreplications,verbs=get;list;watch
+// +kubebuilder:rbac:groups=dev.flemzord.fr,resources=pullsecretreplications/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+// Reconcile replicates the source Secret of a PullSecretReplication
+func (r *PullSecretReplicationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	replication := &devv1alpha1.PullSecretReplication{}
+	if err := r.Get(ctx, req.NamespacedName, replication); err != nil {
+		// The copies are garbage collected through their owner reference
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !replication.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	condition := metav1.Condition{
+		Type:               devv1alpha1.ConditionReplicated,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: replication.Generation,
+	}
+	source := &corev1.Secret{}
+	sourceKey := types.NamespacedName{
+		Namespace: replication.Spec.SourceSecretRef.Namespace,
+		Name:      replication.Spec.SourceSecretRef.Name,
+	}
+	selector, selectorErr := namespaceSelector(replication)
+	switch err := r.apiReader().Get(ctx, sourceKey, source); {
+	case apierrors.IsNotFound(err):
+		// Keep the copies, pods may still pull with them
+		condition.Reason, condition.Message = "SourceNotFound", fmt.Sprintf("Secret %s not found", sourceKey)
+	case err != nil:
+		return ctrl.Result{}, err
+	case source.Type != corev1.SecretTypeDockerConfigJson:
+		condition.Reason = "InvalidSource"
+		condition.Message = fmt.Sprintf("Secret %s is of type %s, not %s", sourceKey, source.Type,
+			corev1.SecretTypeDockerConfigJson)
+	case selectorErr != nil:
+		condition.Reason, condition.Message = "InvalidSelector", selectorErr.Error()
+	default:
+		replicated, conflicts, err := r.replicate(ctx, replication, source, selector)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		replication.Status.ReplicatedNamespaces = replicated
+		if len(conflicts) > 0 {
+			condition.Reason = "Conflict"
+			condition.Message = "Secrets not managed by the replication exist: " + strings.Join(conflicts, ", ")
+		} else {
+			condition.Status = metav1.ConditionTrue
+			condition.Reason = "Replicated"
+			condition.Message = fmt.Sprintf("Secret %s replicated to %d namespaces", sourceKey, replicated)
+		}
+	}
+	if condition.Status == metav1.ConditionFalse {
+		log.Info("Failed to replicate pull secret", "replication", replication.Name, "reason", condition.Reason,
+			"message", condition.Message)
+	}
+
+	replication.Status.ObservedGeneration = replication.Generation
+	meta.SetStatusCondition(&replication.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, replication); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// replicate copies the source Secret into the selected namespaces and deletes
+// the other copies. It returns the number of namespaces the Secret is
+// replicated to and the Secrets conflicting with copies.
+func (r *PullSecretReplicationReconciler) replicate(
+	ctx context.Context, replication *devv1alpha1.PullSecretReplication, source *corev1.Secret, selector labels.Selector,
+) (int, []string, error) {
+	log := logf.FromContext(ctx)
+	targetName := replicationTargetName(replication)
+
+	namespaces := &corev1.NamespaceList{}
+	if err := r.List(ctx, namespaces, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return 0, nil, err
+	}
+	selected := map[string]bool{}
+	var conflicts []string
+	for _, ns := range namespaces.Items {
+		if ns.Status.Phase == corev1.NamespaceTerminating ||
+			(ns.Name == source.Namespace && targetName == source.Name) {
+			continue
+		}
+
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: ns.Name, Name: targetName}}
+		op, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+			if secret.ResourceVersion != "" && !metav1.IsControlledBy(secret, replication) {
+				return errSecretConflict
+			}
+			if secret.Labels == nil {
+				secret.Labels = map[string]string{}
+			}
+			secret.Labels[ReplicatedSecretLabel] = "true"
+			secret.Type = source.Type
+			secret.Data = maps.Clone(source.Data)
+			return controllerutil.SetControllerReference(replication, secret, r.Scheme())
+		})
+		switch {
+		// Secrets without the label aren't cached, so creating their copy
+		// fails instead
+		case errors.Is(err, errSecretConflict) || apierrors.IsAlreadyExists(err):
+			conflicts = append(conflicts, ns.Name+"/"+targetName)
+			continue
+		case err != nil:
+			return 0, nil, fmt.Errorf("failed to replicate secret to namespace %s: %w", ns.Name, err)
+		case op != controllerutil.OperationResultNone:
+			log.Info("Replicated pull secret", "replication", replication.Name, "secret", ns.Name+"/"+targetName,
+				"operation", op)
+		}
+		selected[ns.Name] = true
+	}
+
+	// Delete the copies of the namespaces no longer selected, or renamed
+	copies := &corev1.SecretList{}
+	if err := r.List(ctx, copies, client.MatchingLabels{ReplicatedSecretLabel: "true"}); err != nil {
+		return 0, nil, err
+	}
+	for _, secret := range copies.Items {
+		if !metav1.IsControlledBy(&secret, replication) || (selected[secret.Namespace] && secret.Name == targetName) {
+			continue
+		}
+		if err := r.Delete(ctx, &secret); client.IgnoreNotFound(err) != nil {
+			return 0, nil, fmt.Errorf("failed to delete replicated secret %s/%s: %w", secret.Namespace, secret.Name, err)
+		}
+		log.Info("Deleted replicated pull secret", "replication", replication.Name,
+			"secret", secret.Namespace+"/"+secret.Name)
+	}
+
+	slices.Sort(conflicts)
+	return len(selected), conflicts, nil
+}
+
+// apiReader returns the reader of the source Secrets
+func (r *PullSecretReplicationReconciler) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// namespaceSelector returns the selector of the namespaces of a replication,
+// selecting all namespaces when empty
+func namespaceSelector(replication *devv1alpha1.PullSecretReplication) (labels.Selector, error) {
+	if replication.Spec.NamespaceSelector == nil {
+		return labels.Everything(), nil
+	}
+	return metav1.LabelSelectorAsSelector(replication.Spec.NamespaceSelector)
+}
+
+// replicationTargetName returns the name of the copies of a replication
+func replicationTargetName(replication *devv1alpha1.PullSecretReplication) string {
+	if replication.Spec.TargetName != "" {
+		return replication.Spec.TargetName
+	}
+	return replication.Spec.SourceSecretRef.Name
+}
+
+// replicationsOfSource enqueues the replications of a source Secret
+func (r *PullSecretReplicationReconciler) replicationsOfSource(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.replications(ctx, func(replication *devv1alpha1.PullSecretReplication) bool {
+		ref := replication.Spec.SourceSecretRef
+		return ref.Namespace == obj.GetNamespace() && ref.Name == obj.GetName()
+	})
+}
+
+// allReplications enqueues all the replications, whose selected namespaces
+// may change with any namespace
+func (r *PullSecretReplicationReconciler) allReplications(ctx context.Context, _ client.Object) []reconcile.Request {
+	return r.replications(ctx, func(*devv1alpha1.PullSecretReplication) bool { return true })
+}
+
+// replications returns requests for the replications matching filter
+func (r *PullSecretReplicationReconciler) replications(
+	ctx context.Context, filter func(*devv1alpha1.PullSecretReplication) bool,
+) []reconcile.Request {
+	list := &devv1alpha1.PullSecretReplicationList{}
+	if err := r.List(ctx, list); err != nil {
+		logf.FromContext(ctx).Error(err, "Failed to list pull secret replications")
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range list.Items {
+		if filter(&list.Items[i]) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: list.Items[i].Name}})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager. Replications are
+// reconciled when their source Secret, their copies or namespaces change.
+// Source Secrets are watched through their metadata only, so that only the
+// copies are cached.
+func (r *PullSecretReplicationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&devv1alpha1.PullSecretReplication{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&corev1.Secret{}).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.replicationsOfSource), builder.OnlyMetadata).
+		Watches(&corev1.Namespace{}, handler.EnqueueRequestsFromMapFunc(r.allReplications)).
+		Named("pullsecretreplication").
+		Complete(r)
+}
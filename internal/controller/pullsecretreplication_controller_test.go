@@ -0,0 +1,211 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var _ = Describe("PullSecretReplication Controller", func() {
+	var (
+		ctx        context.Context
+		c          client.Client
+		reconciler *PullSecretReplicationReconciler
+		source     *corev1.Secret
+	)
+
+	namespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+
+	setup := func(objs ...client.Object) {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(devv1alpha1.AddToScheme(scheme)).To(Succeed())
+		c = fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(append(objs, source,
+				namespace("registry-credentials", nil),
+				namespace("team-a", map[string]string{"mirror": "enabled"}),
+				namespace("team-b", map[string]string{"mirror": "enabled"}),
+				namespace("kube-system", nil),
+				&devv1alpha1.PullSecretReplication{
+					ObjectMeta: metav1.ObjectMeta{Name: "mirror"},
+					Spec: devv1alpha1.PullSecretReplicationSpec{
+						SourceSecretRef: corev1.SecretReference{Namespace: "registry-credentials", Name: "mirror"},
+						NamespaceSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"mirror": "enabled"},
+						},
+						TargetName: "mirror-pull-secret",
+					},
+				},
+			)...).
+			WithStatusSubresource(&devv1alpha1.PullSecretReplication{}).
+			Build()
+		reconciler = &PullSecretReplicationReconciler{Client: c}
+	}
+
+	// labelCached makes the client of the reconciler only see the Secrets
+	// with ReplicatedSecretLabel, as the cache of the manager does
+	labelCached := func() {
+		reconciler.APIReader = c
+		reconciler.Client = interceptor.NewClient(c.(client.WithWatch), interceptor.Funcs{
+			Get: func(ctx context.Context, cl client.WithWatch, key client.ObjectKey, obj client.Object,
+				opts ...client.GetOption) error {
+				if err := cl.Get(ctx, key, obj, opts...); err != nil {
+					return err
+				}
+				if _, ok := obj.(*corev1.Secret); ok && obj.GetLabels()[ReplicatedSecretLabel] != "true" {
+					return apierrors.NewNotFound(corev1.Resource("secrets"), key.Name)
+				}
+				return nil
+			},
+		})
+	}
+
+	reconcileReplication := func() *devv1alpha1.PullSecretReplication {
+		_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: "mirror"}})
+		Expect(err).NotTo(HaveOccurred())
+		replication := &devv1alpha1.PullSecretReplication{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "mirror"}, replication)).To(Succeed())
+		return replication
+	}
+
+	copies := func() map[string][]byte {
+		list := &corev1.SecretList{}
+		Expect(c.List(ctx, list, client.MatchingLabels{ReplicatedSecretLabel: "true"})).To(Succeed())
+		data := map[string][]byte{}
+		for _, secret := range list.Items {
+			Expect(secret.Name).To(Equal("mirror-pull-secret"))
+			Expect(secret.Type).To(Equal(corev1.SecretTypeDockerConfigJson))
+			data[secret.Namespace] = secret.Data[corev1.DockerConfigJsonKey]
+		}
+		return data
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		source = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "registry-credentials", Name: "mirror"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+		}
+	})
+
+	It("should copy the source secret into the selected namespaces", func() {
+		setup()
+		replication := reconcileReplication()
+
+		Expect(copies()).To(Equal(map[string][]byte{
+			"team-a": []byte(`{"auths":{}}`),
+			"team-b": []byte(`{"auths":{}}`),
+		}))
+		Expect(replication.Status.ReplicatedNamespaces).To(Equal(2))
+		Expect(meta.IsStatusConditionTrue(replication.Status.Conditions, devv1alpha1.ConditionReplicated)).To(BeTrue())
+	})
+
+	It("should keep the copies in sync with the source", func() {
+		setup()
+		reconcileReplication()
+
+		source.Data[corev1.DockerConfigJsonKey] = []byte(`{"auths":{"mirror.example.com":{}}}`)
+		Expect(c.Update(ctx, source)).To(Succeed())
+		reconcileReplication()
+
+		Expect(copies()).To(HaveKeyWithValue("team-a", []byte(`{"auths":{"mirror.example.com":{}}}`)))
+	})
+
+	It("should delete the copies of namespaces no longer selected", func() {
+		setup()
+		reconcileReplication()
+
+		ns := &corev1.Namespace{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "team-b"}, ns)).To(Succeed())
+		ns.Labels = nil
+		Expect(c.Update(ctx, ns)).To(Succeed())
+		replication := reconcileReplication()
+
+		Expect(copies()).To(HaveLen(1))
+		Expect(copies()).To(HaveKey("team-a"))
+		Expect(replication.Status.ReplicatedNamespaces).To(Equal(1))
+	})
+
+	It("should not overwrite secrets not managed by the replication", func() {
+		setup(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "mirror-pull-secret"},
+			Data:       map[string][]byte{"token": []byte("owned-by-team-b")},
+		})
+		replication := reconcileReplication()
+
+		secret := &corev1.Secret{}
+		Expect(c.Get(ctx, types.NamespacedName{Namespace: "team-b", Name: "mirror-pull-secret"}, secret)).To(Succeed())
+		Expect(secret.Data).To(HaveKeyWithValue("token", []byte("owned-by-team-b")))
+
+		condition := meta.FindStatusCondition(replication.Status.Conditions, devv1alpha1.ConditionReplicated)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal("Conflict"))
+		Expect(condition.Message).To(ContainSubstring("team-b/mirror-pull-secret"))
+	})
+
+	It("should read the source and detect conflicts with Secrets missing from the cache", func() {
+		setup(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "mirror-pull-secret"},
+			Data:       map[string][]byte{"token": []byte("owned-by-team-b")},
+		})
+		labelCached()
+		replication := reconcileReplication()
+
+		Expect(copies()).To(Equal(map[string][]byte{"team-a": []byte(`{"auths":{}}`)}))
+		condition := meta.FindStatusCondition(replication.Status.Conditions, devv1alpha1.ConditionReplicated)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Reason).To(Equal("Conflict"))
+		Expect(condition.Message).To(ContainSubstring("team-b/mirror-pull-secret"))
+
+		// Copies are updated through the cache
+		source.Data[corev1.DockerConfigJsonKey] = []byte(`{"auths":{"mirror.example.com":{}}}`)
+		Expect(c.Update(ctx, source)).To(Succeed())
+		reconcileReplication()
+		Expect(copies()).To(HaveKeyWithValue("team-a", []byte(`{"auths":{"mirror.example.com":{}}}`)))
+	})
+
+	It("should report a missing or invalid source", func() {
+		source.Type = corev1.SecretTypeOpaque
+		setup()
+		replication := reconcileReplication()
+
+		Expect(copies()).To(BeEmpty())
+		condition := meta.FindStatusCondition(replication.Status.Conditions, devv1alpha1.ConditionReplicated)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Reason).To(Equal("InvalidSource"))
+	})
+})
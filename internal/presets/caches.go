@@ -0,0 +1,79 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package presets
+
+import (
+	"fmt"
+	"regexp"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var (
+	garLocation = regexp.MustCompile(`^[a-z]+[0-9]*(-[a-z]+[0-9]+)?$`)
+	garProject  = regexp.MustCompile(`^[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+)
+
+// expandHarborProxyCache returns the rules redirecting the upstreams to the
+// proxy cache projects of a Harbor registry
+func expandHarborProxyCache(preset *devv1alpha1.HarborProxyCachePreset) ([]devv1alpha1.Rule, error) {
+	return rewriteUpstreams(preset.Registry, knownUpstreams(preset.Projects))
+}
+
+// expandArtifactoryRemote returns the rules redirecting the upstreams to the
+// remote repositories of an Artifactory instance, with the repository path
+// method
+func expandArtifactoryRemote(preset *devv1alpha1.ArtifactoryRemotePreset) ([]devv1alpha1.Rule, error) {
+	return rewriteUpstreams(preset.Registry, knownUpstreams(preset.Repositories))
+}
+
+// expandArtifactRegistryRemote returns the rules redirecting the upstreams
+// to the remote repositories of Google Artifact Registry
+func expandArtifactRegistryRemote(preset *devv1alpha1.ArtifactRegistryRemotePreset) ([]devv1alpha1.Rule, error) {
+	if !garLocation.MatchString(preset.Location) {
+		return nil, fmt.Errorf("invalid location %q", preset.Location)
+	}
+	if !garProject.MatchString(preset.Project) {
+		return nil, fmt.Errorf("invalid project %q", preset.Project)
+	}
+
+	// Repositories are served under the project
+	upstreams := knownUpstreams(preset.Repositories)
+	for i := range upstreams {
+		upstreams[i].prefix = preset.Project + "/" + upstreams[i].prefix
+	}
+	return rewriteUpstreams(preset.Location+"-docker.pkg.dev", upstreams)
+}
+
+// expandMirror returns the rules redirecting arbitrary upstreams to a mirror
+func expandMirror(preset *devv1alpha1.MirrorPreset) ([]devv1alpha1.Rule, error) {
+	upstreams := make([]upstream, 0, len(preset.Upstreams))
+	for _, u := range preset.Upstreams {
+		if !registryHost.MatchString(u.Registry) {
+			return nil, fmt.Errorf("invalid upstream registry %q", u.Registry)
+		}
+		if u.Registry == preset.Registry {
+			return nil, fmt.Errorf("upstream registry %q is the mirror", u.Registry)
+		}
+		upstreams = append(upstreams, upstream{
+			name:   u.Registry,
+			match:  "^" + regexp.QuoteMeta(u.Registry) + "/(.+)$",
+			prefix: u.Prefix,
+		})
+	}
+	return rewriteUpstreams(preset.Registry, upstreams)
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package presets
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var (
+	awsAccountID = regexp.MustCompile(`^[0-9]{12}$`)
+	awsRegion    = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+)
+
+// expandECRPullThroughCache returns the rules redirecting the upstreams to
+// the pull through cache rules of an ECR private registry
+func expandECRPullThroughCache(preset *devv1alpha1.ECRPullThroughCachePreset) ([]devv1alpha1.Rule, error) {
+	if !awsAccountID.MatchString(preset.AccountID) {
+		return nil, fmt.Errorf("invalid AWS account ID %q", preset.AccountID)
+	}
+	if !awsRegion.MatchString(preset.Region) {
+		return nil, fmt.Errorf("invalid AWS region %q", preset.Region)
+	}
+
+	domain := "amazonaws.com"
+	if strings.HasPrefix(preset.Region, "cn-") {
+		domain = "amazonaws.com.cn"
+	}
+	registry := fmt.Sprintf("%s.dkr.ecr.%s.%s", preset.AccountID, preset.Region, domain)
+
+	return rewriteUpstreams(registry, knownUpstreams(preset.Upstreams))
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package presets expands the presets of RegistryRewriteRule resources into
+// rewrite rules.
+package presets
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var (
+	// registryHost matches the registry hosts, with their port
+	registryHost = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9.-]*[a-zA-Z0-9])?(?::[0-9]+)?$`)
+	// repositoryPrefix matches the repository prefixes substituted in the
+	// replacement of the rules
+	repositoryPrefix = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*(?:/[a-z0-9]+(?:[._-][a-z0-9]+)*)*$`)
+)
+
+// Expand returns the rules generated by a preset, matching images normalized
+// by the webhook. A nil preset generates no rules.
+func Expand(preset *devv1alpha1.Preset) ([]devv1alpha1.Rule, error) {
+	switch {
+	case preset == nil:
+		return nil, nil
+	case preset.ECRPullThroughCache != nil:
+		return expandECRPullThroughCache(preset.ECRPullThroughCache)
+	case preset.HarborProxyCache != nil:
+		return expandHarborProxyCache(preset.HarborProxyCache)
+	case preset.ArtifactoryRemote != nil:
+		return expandArtifactoryRemote(preset.ArtifactoryRemote)
+	case preset.ArtifactRegistryRemote != nil:
+		return expandArtifactRegistryRemote(preset.ArtifactRegistryRemote)
+	case preset.Mirror != nil:
+		return expandMirror(preset.Mirror)
+	default:
+		return nil, errors.New("no preset set")
+	}
+}
+
+// upstream is an upstream registry cached by a mirror
+type upstream struct {
+	// name names the upstream in errors
+	name string
+	// match matches the normalized images of the upstream, capturing their
+	// repository and reference
+	match string
+	// prefix is the repository prefix of the upstream in the mirror, empty
+	// when served at the root
+	prefix string
+}
+
+// knownUpstreams returns the well-known upstream registries with a prefix.
+// Docker Hub official images keep their library/ namespace, which all the
+// supported caches expect.
+func knownUpstreams(u devv1alpha1.Upstreams) []upstream {
+	var upstreams []upstream
+	for _, known := range []upstream{
+		{name: "dockerhub", match: `^docker\.io/(.+)$`, prefix: u.DockerHub},
+		{name: "ghcr", match: `^ghcr\.io/(.+)$`, prefix: u.GHCR},
+		{name: "quay", match: `^quay\.io/(.+)$`, prefix: u.Quay},
+		{name: "k8s", match: `^registry\.k8s\.io/(.+)$`, prefix: u.Kubernetes},
+		{name: "ecrPublic", match: `^public\.ecr\.aws/(.+)$`, prefix: u.ECRPublic},
+		{name: "gitlab", match: `^registry\.gitlab\.com/(.+)$`, prefix: u.GitLab},
+	} {
+		if known.prefix != "" {
+			upstreams = append(upstreams, known)
+		}
+	}
+	return upstreams
+}
+
+// rewriteUpstreams returns the rules rewriting the images of the upstreams
+// to registry/prefix
+func rewriteUpstreams(registry string, upstreams []upstream) ([]devv1alpha1.Rule, error) {
+	if !registryHost.MatchString(registry) {
+		return nil, fmt.Errorf("invalid registry %q", registry)
+	}
+	if len(upstreams) == 0 {
+		return nil, errors.New("no upstream set")
+	}
+
+	rules := make([]devv1alpha1.Rule, 0, len(upstreams))
+	for _, u := range upstreams {
+		replace := registry + "/$1"
+		if u.prefix != "" {
+			if !repositoryPrefix.MatchString(u.prefix) {
+				return nil, fmt.Errorf("invalid repository prefix %q of upstream %s", u.prefix, u.name)
+			}
+			replace = registry + "/" + u.prefix + "/$1"
+		}
+		rules = append(rules, devv1alpha1.Rule{Match: u.match, Replace: replace})
+	}
+	return rules, nil
+}
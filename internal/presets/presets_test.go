@@ -0,0 +1,239 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package presets
+
+import (
+	"regexp"
+	"testing"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+const testDigest = "sha256:c5b1261d6d3e43071626931fc004f70149baeba2c8ec672bd4f27761f8e1ad6b"
+
+// rewrite applies the first rule matching a normalized image, as the
+// webhook does, and returns the image unchanged when no rule matches. Images
+// are normalized by the webhook, whose tests cover presets end to end.
+func rewrite(t *testing.T, rules []devv1alpha1.Rule, image string) string {
+	t.Helper()
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Match)
+		if err != nil {
+			t.Fatalf("invalid match %q: %v", rule.Match, err)
+		}
+		if re.MatchString(image) {
+			return re.ReplaceAllString(image, rule.Replace)
+		}
+	}
+	return image
+}
+
+// expandCases expands a preset and checks the rewrite of each image
+func expandCases(t *testing.T, preset *devv1alpha1.Preset, cases map[string]string) {
+	t.Helper()
+	rules, err := Expand(preset)
+	if err != nil {
+		t.Fatalf("Expand() error = %v", err)
+	}
+	for image, want := range cases {
+		if got := rewrite(t, rules, image); got != want {
+			t.Errorf("rewrite(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+func TestExpandNil(t *testing.T) {
+	rules, err := Expand(nil)
+	if err != nil || rules != nil {
+		t.Fatalf("Expand(nil) = %v, %v, want no rules", rules, err)
+	}
+	if _, err := Expand(&devv1alpha1.Preset{}); err == nil {
+		t.Fatal("Expand() of an empty preset should fail")
+	}
+}
+
+func TestExpandECRPullThroughCache(t *testing.T) {
+	const ecr = "111122223333.dkr.ecr.eu-west-1.amazonaws.com"
+	expandCases(t, &devv1alpha1.Preset{ECRPullThroughCache: &devv1alpha1.ECRPullThroughCachePreset{
+		AccountID: "111122223333",
+		Region:    "eu-west-1",
+		Upstreams: devv1alpha1.Upstreams{
+			DockerHub:  "docker-hub",
+			GHCR:       "github",
+			Kubernetes: "k8s",
+			ECRPublic:  "ecr-public",
+		},
+	}}, map[string]string{
+		"docker.io/library/nginx:1.25":                ecr + "/docker-hub/library/nginx:1.25",
+		"docker.io/library/nginx@" + testDigest:       ecr + "/docker-hub/library/nginx@" + testDigest,
+		"docker.io/bitnami/redis:7.2":                 ecr + "/docker-hub/bitnami/redis:7.2",
+		"ghcr.io/fluxcd/source-controller:v1.3.0":     ecr + "/github/fluxcd/source-controller:v1.3.0",
+		"registry.k8s.io/ingress-nginx/controller:v1": ecr + "/k8s/ingress-nginx/controller:v1",
+		"public.ecr.aws/eks/aws-load-balancer:v2.7":   ecr + "/ecr-public/eks/aws-load-balancer:v2.7",
+		// Upstreams without a prefix and other registries are kept
+		"quay.io/prometheus/prometheus:v2.51":  "quay.io/prometheus/prometheus:v2.51",
+		ecr + "/docker-hub/library/nginx:1.25": ecr + "/docker-hub/library/nginx:1.25",
+		"registry.example.com:5000/app:1.0":    "registry.example.com:5000/app:1.0",
+	})
+}
+
+func TestExpandECRPullThroughCacheChinaRegion(t *testing.T) {
+	expandCases(t, &devv1alpha1.Preset{ECRPullThroughCache: &devv1alpha1.ECRPullThroughCachePreset{
+		AccountID: "111122223333",
+		Region:    "cn-north-1",
+		Upstreams: devv1alpha1.Upstreams{Quay: "quay"},
+	}}, map[string]string{
+		"quay.io/jetstack/cert-manager-controller:v1.14": "111122223333.dkr.ecr.cn-north-1.amazonaws.com.cn/quay/" +
+			"jetstack/cert-manager-controller:v1.14",
+	})
+}
+
+func TestExpandECRPullThroughCacheInvalid(t *testing.T) {
+	valid := func() *devv1alpha1.ECRPullThroughCachePreset {
+		return &devv1alpha1.ECRPullThroughCachePreset{
+			AccountID: "111122223333",
+			Region:    "eu-west-1",
+			Upstreams: devv1alpha1.Upstreams{DockerHub: "docker-hub"},
+		}
+	}
+	tests := map[string]func(p *devv1alpha1.ECRPullThroughCachePreset){
+		"account ID":      func(p *devv1alpha1.ECRPullThroughCachePreset) { p.AccountID = "1111" },
+		"region":          func(p *devv1alpha1.ECRPullThroughCachePreset) { p.Region = "europe" },
+		"no upstream":     func(p *devv1alpha1.ECRPullThroughCachePreset) { p.Upstreams = devv1alpha1.Upstreams{} },
+		"prefix":          func(p *devv1alpha1.ECRPullThroughCachePreset) { p.Upstreams.DockerHub = "Docker Hub" },
+		"prefix template": func(p *devv1alpha1.ECRPullThroughCachePreset) { p.Upstreams.GHCR = "$1" },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			preset := valid()
+			mutate(preset)
+			if _, err := Expand(&devv1alpha1.Preset{ECRPullThroughCache: preset}); err == nil {
+				t.Error("Expand() should fail")
+			}
+		})
+	}
+}
+
+func TestExpandHarborProxyCache(t *testing.T) {
+	const harbor = "harbor.example.com:8443"
+	expandCases(t, &devv1alpha1.Preset{HarborProxyCache: &devv1alpha1.HarborProxyCachePreset{
+		Registry: harbor,
+		Projects: devv1alpha1.Upstreams{DockerHub: "dockerhub-proxy", Quay: "quay-proxy"},
+	}}, map[string]string{
+		"docker.io/library/nginx:1.25":            harbor + "/dockerhub-proxy/library/nginx:1.25",
+		"docker.io/library/nginx@" + testDigest:   harbor + "/dockerhub-proxy/library/nginx@" + testDigest,
+		"docker.io/grafana/grafana:10.4.1":        harbor + "/dockerhub-proxy/grafana/grafana:10.4.1",
+		"quay.io/prometheus/node-exporter:v1.7.0": harbor + "/quay-proxy/prometheus/node-exporter:v1.7.0",
+		"ghcr.io/fluxcd/flux-cli:v2.2.3":          "ghcr.io/fluxcd/flux-cli:v2.2.3",
+		"registry.example.com:5000/app:1.0":       "registry.example.com:5000/app:1.0",
+	})
+}
+
+func TestExpandArtifactoryRemote(t *testing.T) {
+	const artifactory = "acme.jfrog.io"
+	expandCases(t, &devv1alpha1.Preset{ArtifactoryRemote: &devv1alpha1.ArtifactoryRemotePreset{
+		Registry:     artifactory,
+		Repositories: devv1alpha1.Upstreams{DockerHub: "docker-remote", GHCR: "ghcr-remote"},
+	}}, map[string]string{
+		"docker.io/library/redis:7.2":               artifactory + "/docker-remote/library/redis:7.2",
+		"docker.io/bitnami/redis@" + testDigest:     artifactory + "/docker-remote/bitnami/redis@" + testDigest,
+		"ghcr.io/external-secrets/external-secrets": artifactory + "/ghcr-remote/external-secrets/external-secrets",
+		"registry.example.com:5000/app:1.0":         "registry.example.com:5000/app:1.0",
+	})
+}
+
+func TestExpandArtifactRegistryRemote(t *testing.T) {
+	const gar = "europe-west1-docker.pkg.dev/acme-prod"
+	expandCases(t, &devv1alpha1.Preset{ArtifactRegistryRemote: &devv1alpha1.ArtifactRegistryRemotePreset{
+		Location:     "europe-west1",
+		Project:      "acme-prod",
+		Repositories: devv1alpha1.Upstreams{DockerHub: "dockerhub", Kubernetes: "k8s"},
+	}}, map[string]string{
+		"docker.io/library/postgres:16":                gar + "/dockerhub/library/postgres:16",
+		"docker.io/library/postgres@" + testDigest:     gar + "/dockerhub/library/postgres@" + testDigest,
+		"docker.io/timberio/vector:0.37.0-debian":      gar + "/dockerhub/timberio/vector:0.37.0-debian",
+		"registry.k8s.io/kube-state-metrics/ksm:v2.11": gar + "/k8s/kube-state-metrics/ksm:v2.11",
+		"registry.example.com:5000/app:1.0":            "registry.example.com:5000/app:1.0",
+	})
+}
+
+func TestExpandMirror(t *testing.T) {
+	const mirror = "mirror.internal:5000"
+	expandCases(t, &devv1alpha1.Preset{Mirror: &devv1alpha1.MirrorPreset{
+		Registry: mirror,
+		Upstreams: []devv1alpha1.MirrorUpstream{
+			{Registry: "docker.io"},
+			{Registry: "registry.example.com:5000", Prefix: "example"},
+		},
+	}}, map[string]string{
+		"docker.io/library/nginx:1.25":                    mirror + "/library/nginx:1.25",
+		"docker.io/library/nginx@" + testDigest:           mirror + "/library/nginx@" + testDigest,
+		"docker.io/bitnami/redis:7.2":                     mirror + "/bitnami/redis:7.2",
+		"registry.example.com:5000/team/app:1.0":          mirror + "/example/team/app:1.0",
+		"registry.example.com:5000/app@" + testDigest:     mirror + "/example/app@" + testDigest,
+		"registry.example.com/team/app:1.0":               "registry.example.com/team/app:1.0",
+		"registry.example.com:50001/team/app:1.0":         "registry.example.com:50001/team/app:1.0",
+		"quay.io/prometheus/prometheus:v2.51":             "quay.io/prometheus/prometheus:v2.51",
+		mirror + "/library/nginx:1.25":                    mirror + "/library/nginx:1.25",
+		"registry.example.com.evil.io/team/app:1.0":       "registry.example.com.evil.io/team/app:1.0",
+		"docker.io.evil.io/library/nginx:1.25":            "docker.io.evil.io/library/nginx:1.25",
+		"registry.example.com:5000/nested/team/app:1.0":   mirror + "/example/nested/team/app:1.0",
+		"registry.example.com:5000/team/app:1.0-debian12": mirror + "/example/team/app:1.0-debian12",
+	})
+}
+
+func TestExpandInvalid(t *testing.T) {
+	tests := map[string]*devv1alpha1.Preset{
+		"harbor registry": {HarborProxyCache: &devv1alpha1.HarborProxyCachePreset{
+			Registry: "https://harbor.example.com",
+			Projects: devv1alpha1.Upstreams{DockerHub: "proxy"},
+		}},
+		"harbor without project": {HarborProxyCache: &devv1alpha1.HarborProxyCachePreset{
+			Registry: "harbor.example.com",
+		}},
+		"artifactory repository": {ArtifactoryRemote: &devv1alpha1.ArtifactoryRemotePreset{
+			Registry:     "acme.jfrog.io",
+			Repositories: devv1alpha1.Upstreams{DockerHub: "docker remote"},
+		}},
+		"artifact registry location": {ArtifactRegistryRemote: &devv1alpha1.ArtifactRegistryRemotePreset{
+			Location:     "Europe West",
+			Project:      "acme-prod",
+			Repositories: devv1alpha1.Upstreams{DockerHub: "dockerhub"},
+		}},
+		"artifact registry project": {ArtifactRegistryRemote: &devv1alpha1.ArtifactRegistryRemotePreset{
+			Location:     "us",
+			Project:      "acme",
+			Repositories: devv1alpha1.Upstreams{DockerHub: "dockerhub"},
+		}},
+		"mirror without upstream": {Mirror: &devv1alpha1.MirrorPreset{Registry: "mirror.internal"}},
+		"mirror upstream": {Mirror: &devv1alpha1.MirrorPreset{
+			Registry:  "mirror.internal",
+			Upstreams: []devv1alpha1.MirrorUpstream{{Registry: "docker.io/library"}},
+		}},
+		"mirror of itself": {Mirror: &devv1alpha1.MirrorPreset{
+			Registry:  "mirror.internal",
+			Upstreams: []devv1alpha1.MirrorUpstream{{Registry: "mirror.internal"}},
+		}},
+	}
+	for name, preset := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Expand(preset); err == nil {
+				t.Error("Expand() should fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,373 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package registry implements a minimal OCI distribution client used to
+// inspect the registries images are rewritten to.
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// DefaultTimeout is the default timeout of a registry request
+	DefaultTimeout = 2 * time.Second
+	// DefaultCacheTTL is the default lifetime of cached manifest lookups
+	DefaultCacheTTL = 5 * time.Minute
+)
+
+// manifestMediaTypes are the manifest media types accepted when looking up
+// a manifest, so that registries return the digest of image indexes
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// ErrManifestNotFound is returned when the registry doesn't have the manifest
+var ErrManifestNotFound = errors.New("manifest not found")
+
+var requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "registry_rewriter_registry_requests_total",
+	Help: "Total number of requests sent to registries",
+}, []string{"registry", "code"})
+
+func init() {
+	metrics.Registry.MustRegister(requestsTotal)
+}
+
+// Reference identifies a manifest in a registry
+type Reference struct {
+	// Registry is the registry host, with its port
+	Registry string
+	// Repository is the repository path within the registry
+	Repository string
+	// Reference is a tag or a digest
+	Reference string
+	// Insecure sends the requests over plain HTTP instead of HTTPS
+	Insecure bool
+}
+
+// String returns the reference as an image name
+func (r Reference) String() string {
+	if strings.Contains(r.Reference, ":") {
+		return r.Registry + "/" + r.Repository + "@" + r.Reference
+	}
+	return r.Registry + "/" + r.Repository + ":" + r.Reference
+}
+
+// Credentials authenticate requests to a registry
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// CredentialsFunc returns the credentials of a registry, or nil for
+// anonymous access. It is only called when a request is sent.
+type CredentialsFunc func(ctx context.Context) (*Credentials, error)
+
+// Manifest describes a manifest found in a registry
+type Manifest struct {
+	// Digest is the content digest of the manifest
+	Digest string
+}
+
+// cacheEntry is a cached manifest lookup
+type cacheEntry struct {
+	manifest Manifest
+	err      error
+	expires  time.Time
+}
+
+// Client looks up manifests in OCI distribution registries
+type Client struct {
+	// HTTPClient sends the requests. http.DefaultClient is used when nil.
+	HTTPClient *http.Client
+	// Timeout bounds each lookup. DefaultTimeout is used when zero.
+	Timeout time.Duration
+	// CacheTTL is the lifetime of cached lookups, including lookups of
+	// missing manifests. DefaultCacheTTL is used when zero.
+	CacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+// HeadManifest looks up a manifest with a HEAD /v2/<repo>/manifests/<ref>
+// request. It returns ErrManifestNotFound when the registry doesn't have it.
+// Results, including missing manifests, are cached per identity, which
+// identifies the credentials, such as the namespace/name of their secret, and
+// is empty for anonymous access. The lookup is bounded by Timeout and by the
+// deadline of ctx.
+func (c *Client) HeadManifest(
+	ctx context.Context, ref Reference, identity string, credentials CredentialsFunc,
+) (Manifest, error) {
+	key := identity + "|" + ref.String()
+	if entry, ok := c.cached(key); ok {
+		return entry.manifest, entry.err
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	manifest, err := c.headManifest(ctx, ref, credentials)
+	if err == nil || errors.Is(err, ErrManifestNotFound) {
+		c.store(key, manifest, err)
+	}
+	return manifest, err
+}
+
+// Ping checks that a registry serves the distribution API with a GET /v2/
+// request, over plain HTTP when insecure. Registries requiring authentication
+// are considered up.
+func (c *Client) Ping(ctx context.Context, registry string, insecure bool) error {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL(registry, insecure)+"/v2/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		requestsTotal.WithLabelValues(registry, "error").Inc()
+		return err
+	}
+	_ = resp.Body.Close()
+	requestsTotal.WithLabelValues(registry, strconv.Itoa(resp.StatusCode)).Inc()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, registry)
+	}
+	return nil
+}
+
+// headManifest sends the HEAD request, authenticating when challenged
+func (c *Client) headManifest(ctx context.Context, ref Reference, credentials CredentialsFunc) (Manifest, error) {
+	manifestURL := fmt.Sprintf("%s/v2/%s/manifests/%s", baseURL(ref.Registry, ref.Insecure), ref.Repository, ref.Reference)
+
+	resp, err := c.do(ctx, ref.Registry, manifestURL, "")
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		var creds *Credentials
+		if credentials != nil {
+			if creds, err = credentials(ctx); err != nil {
+				return Manifest{}, fmt.Errorf("failed to get credentials for %s: %w", ref.Registry, err)
+			}
+		}
+		authorization, err := c.authorize(ctx, resp.Header.Get("WWW-Authenticate"), creds)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("failed to authenticate to %s: %w", ref.Registry, err)
+		}
+		if resp, err = c.do(ctx, ref.Registry, manifestURL, authorization); err != nil {
+			return Manifest{}, err
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return Manifest{Digest: resp.Header.Get("Docker-Content-Digest")}, nil
+	case http.StatusNotFound:
+		return Manifest{}, ErrManifestNotFound
+	default:
+		return Manifest{}, fmt.Errorf("unexpected status %d looking up %s", resp.StatusCode, ref)
+	}
+}
+
+// do sends a HEAD request for a manifest
+func (c *Client) do(ctx context.Context, registry, manifestURL, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		requestsTotal.WithLabelValues(registry, "error").Inc()
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	requestsTotal.WithLabelValues(registry, strconv.Itoa(resp.StatusCode)).Inc()
+	return resp, nil
+}
+
+// authorize answers an authentication challenge and returns the value of the
+// Authorization header to retry the request with
+func (c *Client) authorize(ctx context.Context, challenge string, creds *Credentials) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if creds == nil {
+			return "", errors.New("registry requires credentials")
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds.Username+":"+creds.Password)), nil
+	case "bearer":
+		token, err := c.fetchToken(ctx, params, creds)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	default:
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+}
+
+// fetchToken gets a bearer token from the realm of a challenge
+func (c *Client) fetchToken(ctx context.Context, params map[string]string, creds *Credentials) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if creds != nil {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d from token endpoint", resp.StatusCode)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode token: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", errors.New("token endpoint returned no token")
+}
+
+// cached returns the cached lookup of key, if not expired
+func (c *Client) cached(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// store caches the lookup of key
+func (c *Client) store(key string, manifest Manifest, err error) {
+	ttl := c.CacheTTL
+	if ttl == 0 {
+		ttl = DefaultCacheTTL
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		c.cache = map[string]cacheEntry{}
+	}
+	now := time.Now()
+	for k, entry := range c.cache {
+		if now.After(entry.expires) {
+			delete(c.cache, k)
+		}
+	}
+	c.cache[key] = cacheEntry{manifest: manifest, err: err, expires: now.Add(ttl)}
+}
+
+// httpClient returns the HTTP client sending the requests
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// baseURL returns the base URL of the distribution API of a registry, served
+// over plain HTTP when insecure
+func baseURL(registry string, insecure bool) string {
+	if registry == "docker.io" {
+		registry = "registry-1.docker.io"
+	}
+	if insecure {
+		return "http://" + registry
+	}
+	return "https://" + registry
+}
+
+// parseChallenge parses a WWW-Authenticate header such as
+// Bearer realm="https://auth.example.com/token",service="registry"
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := map[string]string{}
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key != "" {
+			params[strings.ToLower(strings.TrimSpace(key))] = value
+		}
+	}
+	return scheme, params
+}
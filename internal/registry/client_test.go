@@ -0,0 +1,176 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/flemzord/mutating-registry-webhook/internal/registry/registrytest"
+)
+
+const testDigest = "sha256:c5b1261d6d3e43071626931fc004f70149baeba2c8ec672bd4f27761f8e1ad6b"
+
+func TestHeadManifest(t *testing.T) {
+	server := registrytest.NewServer()
+	defer server.Close()
+	server.AddManifest("library/nginx", "1.25", testDigest)
+
+	c := &Client{HTTPClient: server.Client()}
+	ctx := context.Background()
+
+	manifest, err := c.HeadManifest(ctx, Reference{Registry: server.Host(), Repository: "library/nginx", Reference: "1.25"}, "", nil)
+	if err != nil {
+		t.Fatalf("HeadManifest returned error: %v", err)
+	}
+	if manifest.Digest != testDigest {
+		t.Errorf("HeadManifest digest = %q, want %q", manifest.Digest, testDigest)
+	}
+
+	_, err = c.HeadManifest(ctx, Reference{Registry: server.Host(), Repository: "library/nginx", Reference: testDigest}, "", nil)
+	if err != nil {
+		t.Errorf("HeadManifest by digest returned error: %v", err)
+	}
+
+	_, err = c.HeadManifest(ctx, Reference{Registry: server.Host(), Repository: "library/redis", Reference: "7"}, "", nil)
+	if !errors.Is(err, ErrManifestNotFound) {
+		t.Errorf("HeadManifest of a missing manifest returned %v, want ErrManifestNotFound", err)
+	}
+}
+
+func TestHeadManifestCache(t *testing.T) {
+	server := registrytest.NewServer()
+	defer server.Close()
+	server.AddManifest("library/nginx", "1.25", testDigest)
+
+	c := &Client{HTTPClient: server.Client()}
+	ctx := context.Background()
+	for _, ref := range []string{"1.25", "1.25", "missing", "missing"} {
+		_, _ = c.HeadManifest(ctx, Reference{Registry: server.Host(), Repository: "library/nginx", Reference: ref}, "", nil)
+	}
+	if server.Requests() != 2 {
+		t.Errorf("registry received %d requests, want 2", server.Requests())
+	}
+}
+
+func TestPing(t *testing.T) {
+	server := registrytest.NewServer()
+	defer server.Close()
+
+	c := &Client{HTTPClient: server.Client()}
+	ctx := context.Background()
+	if err := c.Ping(ctx, server.Host(), false); err != nil {
+		t.Errorf("Ping returned error: %v", err)
+	}
+
+	server.Username, server.Password = "user", "secret"
+	if err := c.Ping(ctx, server.Host(), false); err != nil {
+		t.Errorf("Ping of a registry requiring authentication returned error: %v", err)
+	}
+
+	server.SetDown(true)
+	if err := c.Ping(ctx, server.Host(), false); err == nil {
+		t.Error("Ping of an unavailable registry returned no error")
+	}
+}
+
+func TestInsecureRegistry(t *testing.T) {
+	server := registrytest.NewInsecureServer()
+	defer server.Close()
+	server.AddManifest("library/nginx", "1.25", testDigest)
+
+	c := &Client{HTTPClient: server.Client()}
+	ctx := context.Background()
+	if err := c.Ping(ctx, server.Host(), false); err == nil {
+		t.Error("Ping over HTTPS of a plain HTTP registry returned no error")
+	}
+	if err := c.Ping(ctx, server.Host(), true); err != nil {
+		t.Errorf("Ping of an insecure registry returned error: %v", err)
+	}
+
+	ref := Reference{Registry: server.Host(), Repository: "library/nginx", Reference: "1.25", Insecure: true}
+	manifest, err := c.HeadManifest(ctx, ref, "", nil)
+	if err != nil {
+		t.Fatalf("HeadManifest returned error: %v", err)
+	}
+	if manifest.Digest != testDigest {
+		t.Errorf("digest = %q, want %q", manifest.Digest, testDigest)
+	}
+}
+
+func TestHeadManifestAuthentication(t *testing.T) {
+	server := registrytest.NewServer()
+	defer server.Close()
+	server.Username, server.Password = "user", "secret"
+	server.AddManifest("team/app", "v1", testDigest)
+
+	ctx := context.Background()
+	ref := Reference{Registry: server.Host(), Repository: "team/app", Reference: "v1"}
+
+	c := &Client{HTTPClient: server.Client()}
+	if _, err := c.HeadManifest(ctx, ref, "", nil); err == nil {
+		t.Error("HeadManifest without credentials succeeded, want error")
+	}
+
+	c = &Client{HTTPClient: server.Client()}
+	credentials := func(context.Context) (*Credentials, error) {
+		return &Credentials{Username: "user", Password: "secret"}, nil
+	}
+	if _, err := c.HeadManifest(ctx, ref, "default/registry", credentials); err != nil {
+		t.Errorf("HeadManifest with credentials returned error: %v", err)
+	}
+
+	// Lookups with other credentials don't share the cached result
+	if _, err := c.HeadManifest(ctx, ref, "", nil); err == nil {
+		t.Error("HeadManifest without credentials after a cached lookup succeeded, want error")
+	}
+}
+
+func TestCredentialsFromSecret(t *testing.T) {
+	secret := &corev1.Secret{
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{` +
+				`"https://index.docker.io/v1/":{"username":"hub","password":"hub-secret"},` +
+				`"mirror.example.com":{"auth":"dXNlcjpwYXNzOndvcmQ="}}}`),
+		},
+	}
+
+	tests := []struct {
+		registry string
+		expected *Credentials
+	}{
+		{registry: "docker.io", expected: &Credentials{Username: "hub", Password: "hub-secret"}},
+		{registry: "mirror.example.com", expected: &Credentials{Username: "user", Password: "pass:word"}},
+		{registry: "quay.io", expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.registry, func(t *testing.T) {
+			creds, err := CredentialsFromSecret(secret, tt.registry)
+			if err != nil {
+				t.Fatalf("CredentialsFromSecret returned error: %v", err)
+			}
+			if (creds == nil) != (tt.expected == nil) || (creds != nil && *creds != *tt.expected) {
+				t.Errorf("CredentialsFromSecret(%q) = %+v, want %+v", tt.registry, creds, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// dockerConfig is the content of a dockerconfigjson Secret
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+// dockerAuth holds the credentials of a registry in a docker config
+type dockerAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// CredentialsFromSecret returns the credentials of a registry stored in a
+// kubernetes.io/dockerconfigjson or kubernetes.io/dockercfg Secret, or nil
+// when the Secret has none for the registry
+func CredentialsFromSecret(secret *corev1.Secret, registry string) (*Credentials, error) {
+	var config dockerConfig
+	switch {
+	case secret.Data[corev1.DockerConfigJsonKey] != nil:
+		if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", corev1.DockerConfigJsonKey, err)
+		}
+	case secret.Data[corev1.DockerConfigKey] != nil:
+		if err := json.Unmarshal(secret.Data[corev1.DockerConfigKey], &config.Auths); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", corev1.DockerConfigKey, err)
+		}
+	default:
+		return nil, errors.New("secret holds no docker config")
+	}
+
+	for server, auth := range config.Auths {
+		if registryHost(server) != registry {
+			continue
+		}
+		if auth.Username == "" && auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode auth of %s: %w", server, err)
+			}
+			auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		return &Credentials{Username: auth.Username, Password: auth.Password}, nil
+	}
+	return nil, nil
+}
+
+// registryHost returns the registry host of a docker config server, which
+// may be a URL such as https://index.docker.io/v1/
+func registryHost(server string) string {
+	server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	server, _, _ = strings.Cut(server, "/")
+	switch server {
+	case "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return server
+}
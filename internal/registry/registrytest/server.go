@@ -0,0 +1,142 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package registrytest provides an in-process OCI distribution registry for
+// tests.
+package registrytest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// token is the bearer token issued by the registry
+const token = "registrytest-token"
+
+// Server is an in-process registry serving manifest lookups over TLS, or
+// plain HTTP. Use the client returned by Client to trust its certificate.
+type Server struct {
+	*httptest.Server
+
+	// Username and Password, when set, are required to get a bearer token
+	Username string
+	Password string
+
+	mu        sync.Mutex
+	manifests map[string]string
+	requests  int
+	down      bool
+}
+
+// NewServer starts a registry. The caller must Close it.
+func NewServer() *Server {
+	s := &Server{manifests: map[string]string{}}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// NewInsecureServer starts a registry served over plain HTTP. The caller must
+// Close it.
+func NewInsecureServer() *Server {
+	s := &Server{manifests: map[string]string{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Host returns the host of the registry, as used in image references
+func (s *Server) Host() string {
+	return strings.TrimPrefix(strings.TrimPrefix(s.URL, "https://"), "http://")
+}
+
+// AddManifest adds a manifest to the registry, reachable by tag and digest
+func (s *Server) AddManifest(repository, tag, digest string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.manifests[repository+":"+tag] = digest
+	s.manifests[repository+"@"+digest] = digest
+}
+
+// SetDown makes the registry answer every request with 503 Service
+// Unavailable, or serve requests again
+func (s *Server) SetDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+// Requests returns the number of manifest requests received
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// serveHTTP implements the subset of the distribution API used by the webhook
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	down := s.down
+	s.mu.Unlock()
+	if down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.URL.Path == "/token" {
+		if user, password, _ := r.BasicAuth(); user != s.Username || password != s.Password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"token":%q}`, token)
+		return
+	}
+
+	if s.Username != "" && r.Header.Get("Authorization") != "Bearer "+token {
+		w.Header().Set("WWW-Authenticate",
+			fmt.Sprintf(`Bearer realm="%s/token",service="registrytest"`, s.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == "/v2/" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	repository, reference, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v2/"), "/manifests/")
+	if !ok || r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests++
+	separator := ":"
+	if strings.Contains(reference, ":") {
+		separator = "@"
+	}
+	digest, found := s.manifests[repository+separator+reference]
+	s.mu.Unlock()
+
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusOK)
+}
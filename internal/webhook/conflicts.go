@@ -0,0 +1,295 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+const (
+	// conflictShadowed is reported when a rule can never fire because a
+	// higher-priority rule always matches first
+	conflictShadowed = "shadowed"
+	// conflictAmbiguous is reported when two rules with the same priority
+	// rewrite the same image to different targets
+	conflictAmbiguous = "ambiguous"
+)
+
+// DefaultSampleImages is the image corpus used to analyze the rule set
+var DefaultSampleImages = []string{
+	"nginx:latest",
+	"busybox",
+	"docker.io/library/nginx:1.25",
+	"docker.io/bitnami/redis:7.2",
+	"docker.io/library/alpine@sha256:c5b1261d6d3e43071626931fc004f70149baeba2c8ec672bd4f27761f8e1ad6b",
+	"gcr.io/google-containers/pause:3.9",
+	"registry.k8s.io/pause:3.9",
+	"quay.io/prometheus/node-exporter:v1.7.0",
+	"ghcr.io/fluxcd/source-controller:v1.2.0",
+	"public.ecr.aws/eks-distro/kubernetes/pause:3.9",
+	"mcr.microsoft.com/dotnet/runtime:8.0",
+	"localhost:5000/app:dev",
+}
+
+var ruleConflicts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "registry_rewriter_rule_conflicts",
+	Help: "Number of conflicts found in the global rule set",
+}, []string{"type"})
+
+func init() {
+	metrics.Registry.MustRegister(ruleConflicts)
+}
+
+// ruleConflict describes a problem between rules of the global rule set
+type ruleConflict struct {
+	kind    string
+	rule    compiledRule
+	others  []compiledRule
+	message string
+}
+
+// resources returns the names of the RegistryRewriteRule resources involved
+// in the conflict
+func (c ruleConflict) resources() []string {
+	var names []string
+	for _, rule := range slices.Concat([]compiledRule{c.rule}, c.others) {
+		if !rule.mirror && !slices.Contains(names, rule.ruleName) {
+			names = append(names, rule.ruleName)
+		}
+	}
+	return names
+}
+
+// detectConflicts analyzes rules, which must be in evaluation order, against
+// the sample corpus and the images derived from each rule's match pattern
+func detectConflicts(rules []compiledRule, samples []string) []ruleConflict {
+	corpus := buildCorpus(rules, samples)
+
+	// Record which sample images each rule matches
+	matches := make([][]bool, len(rules))
+	for i, rule := range rules {
+		matches[i] = make([]bool, len(corpus))
+		for k, image := range corpus {
+			matches[i][k] = rule.regex.MatchString(image)
+		}
+	}
+
+	var conflicts []ruleConflict
+	for j, rule := range rules {
+		if c, ok := findShadowing(rules, matches, corpus, j); ok {
+			conflicts = append(conflicts, c)
+		}
+
+		for i := 0; i < j; i++ {
+			other := rules[i]
+			if other.rule.Priority != rule.rule.Priority || !conditionsOverlap(other.rule.Conditions, rule.rule.Conditions) {
+				continue
+			}
+			for k, image := range corpus {
+				if !matches[i][k] || !matches[j][k] {
+					continue
+				}
+				first := other.regex.ReplaceAllString(image, other.replace)
+				second := rule.regex.ReplaceAllString(image, rule.replace)
+				if first == second {
+					continue
+				}
+				conflicts = append(conflicts, ruleConflict{
+					kind:   conflictAmbiguous,
+					rule:   rule,
+					others: []compiledRule{other},
+					message: fmt.Sprintf("rule %s conflicts with rule %s at priority %d: %q is rewritten to %q and %q",
+						ruleRef(rule), ruleRef(other), rule.rule.Priority, image, first, second),
+				})
+				break
+			}
+		}
+	}
+
+	return conflicts
+}
+
+// findShadowing reports whether every sample image matched by rules[j] is
+// already matched by a higher-priority rule applying to the same pods
+func findShadowing(rules []compiledRule, matches [][]bool, corpus []string, j int) (ruleConflict, bool) {
+	rule := rules[j]
+	var shadowing []compiledRule
+	matched := false
+	for k := range corpus {
+		if !matches[j][k] {
+			continue
+		}
+		matched = true
+
+		covered := false
+		for i := 0; i < j; i++ {
+			other := rules[i]
+			if other.rule.Priority <= rule.rule.Priority || !matches[i][k] ||
+				!conditionsCover(other.rule.Conditions, rule.rule.Conditions) {
+				continue
+			}
+			covered = true
+			if !slices.ContainsFunc(shadowing, func(r compiledRule) bool { return ruleRef(r) == ruleRef(other) }) {
+				shadowing = append(shadowing, other)
+			}
+			break
+		}
+		if !covered {
+			return ruleConflict{}, false
+		}
+	}
+	if !matched {
+		return ruleConflict{}, false
+	}
+
+	refs := make([]string, 0, len(shadowing))
+	for _, other := range shadowing {
+		refs = append(refs, ruleRef(other))
+	}
+	return ruleConflict{
+		kind:   conflictShadowed,
+		rule:   rule,
+		others: shadowing,
+		message: fmt.Sprintf("rule %s can never fire: every image it matches is rewritten first by higher-priority rule %s",
+			ruleRef(rule), strings.Join(refs, ", ")),
+	}, true
+}
+
+// buildCorpus returns the normalized sample images completed with images
+// derived from the literal prefix of each rule's match pattern
+func buildCorpus(rules []compiledRule, samples []string) []string {
+	var corpus []string
+	add := func(image string) {
+		image = normalizeImage(image)
+		if !slices.Contains(corpus, image) {
+			corpus = append(corpus, image)
+		}
+	}
+
+	for _, image := range samples {
+		add(image)
+	}
+	for _, rule := range rules {
+		// LiteralPrefix ignores patterns anchored with ^, so recompile without it
+		unanchored, err := regexp.Compile(strings.TrimPrefix(rule.rule.Match, "^"))
+		if err != nil {
+			continue
+		}
+		prefix, _ := unanchored.LiteralPrefix()
+		if prefix == "" {
+			continue
+		}
+		if strings.HasSuffix(prefix, "/") {
+			add(prefix + "app:1.0")
+			add(prefix + "sample/app:1.0")
+		} else {
+			add(prefix + ":1.0")
+			add(prefix + "/app:1.0")
+		}
+	}
+
+	return corpus
+}
+
+// conditionsCover reports whether a rule with conditions a applies to every
+// pod a rule with conditions b applies to
+func conditionsCover(a, b *devv1alpha1.RuleConditions) bool {
+	if a == nil {
+		return true
+	}
+
+	if len(a.Namespaces) > 0 {
+		if b == nil || len(b.Namespaces) == 0 {
+			return false
+		}
+		for _, ns := range b.Namespaces {
+			if !slices.Contains(a.Namespaces, ns) {
+				return false
+			}
+		}
+	}
+
+	for k, v := range a.Labels {
+		if b == nil || b.Labels[k] != v {
+			return false
+		}
+	}
+
+	if len(a.ImageSources) > 0 {
+		if b == nil || len(b.ImageSources) == 0 {
+			return false
+		}
+		for _, source := range b.ImageSources {
+			if !slices.Contains(a.ImageSources, source) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// conditionsOverlap reports whether some pod can satisfy both a and b
+func conditionsOverlap(a, b *devv1alpha1.RuleConditions) bool {
+	if a == nil || b == nil {
+		return true
+	}
+
+	if len(a.Namespaces) > 0 && len(b.Namespaces) > 0 {
+		shared := false
+		for _, ns := range a.Namespaces {
+			if slices.Contains(b.Namespaces, ns) {
+				shared = true
+				break
+			}
+		}
+		if !shared {
+			return false
+		}
+	}
+
+	for k, v := range a.Labels {
+		if other, ok := b.Labels[k]; ok && other != v {
+			return false
+		}
+	}
+
+	if len(a.ImageSources) > 0 && len(b.ImageSources) > 0 &&
+		!slices.ContainsFunc(a.ImageSources, func(source devv1alpha1.ImageSource) bool {
+			return slices.Contains(b.ImageSources, source)
+		}) {
+		return false
+	}
+
+	return true
+}
+
+// ruleRef returns a human readable reference to a compiled rule
+func ruleRef(rule compiledRule) string {
+	if rule.mirror {
+		return fmt.Sprintf("%s/%s/%d", mirrorRefPrefix, rule.ruleName, rule.index)
+	}
+	return fmt.Sprintf("%s/%d", rule.ruleName, rule.index)
+}
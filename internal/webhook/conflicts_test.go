@@ -0,0 +1,178 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var _ = Describe("Rule conflicts", func() {
+	var ctx context.Context
+
+	newRule := func(name string, rules ...devv1alpha1.Rule) devv1alpha1.RegistryRewriteRule {
+		return devv1alpha1.RegistryRewriteRule{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       devv1alpha1.RegistryRewriteRuleSpec{Rules: rules},
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	Describe("detectConflicts", func() {
+		It("should report a rule shadowed by a higher-priority rule", func() {
+			rules := compileRules(ctx, []devv1alpha1.RegistryRewriteRule{
+				newRule("catch-all", devv1alpha1.Rule{Match: `^docker\.io/(.*)`, Replace: `mirror.local/$1`, Priority: 100}),
+				newRule("nginx", devv1alpha1.Rule{Match: `^docker\.io/library/nginx(.*)`, Replace: `nginx.local/nginx$1`}),
+			}, nil)
+
+			conflicts := detectConflicts(rules, DefaultSampleImages)
+			Expect(conflicts).To(HaveLen(1))
+			Expect(conflicts[0].kind).To(Equal(conflictShadowed))
+			Expect(conflicts[0].resources()).To(ConsistOf("nginx", "catch-all"))
+			Expect(conflicts[0].message).To(ContainSubstring("nginx/0 can never fire"))
+		})
+
+		It("should not report a rule shadowed only in some namespaces", func() {
+			rules := compileRules(ctx, []devv1alpha1.RegistryRewriteRule{
+				newRule("prod", devv1alpha1.Rule{
+					Match:      `^docker\.io/(.*)`,
+					Replace:    `prod.local/$1`,
+					Priority:   100,
+					Conditions: &devv1alpha1.RuleConditions{Namespaces: []string{"production"}},
+				}),
+				newRule("default", devv1alpha1.Rule{Match: `^docker\.io/(.*)`, Replace: `mirror.local/$1`}),
+			}, nil)
+
+			Expect(detectConflicts(rules, DefaultSampleImages)).To(BeEmpty())
+		})
+
+		It("should report rules with the same priority rewriting to different targets", func() {
+			rules := compileRules(ctx, []devv1alpha1.RegistryRewriteRule{
+				newRule("a", devv1alpha1.Rule{Match: `^docker\.io/(.*)`, Replace: `a.local/$1`}),
+				newRule("b", devv1alpha1.Rule{Match: `^docker\.io/library/(.*)`, Replace: `b.local/$1`}),
+			}, nil)
+
+			conflicts := detectConflicts(rules, DefaultSampleImages)
+			Expect(conflicts).To(HaveLen(1))
+			Expect(conflicts[0].kind).To(Equal(conflictAmbiguous))
+			Expect(conflicts[0].message).To(ContainSubstring("rule b/0 conflicts with rule a/0 at priority 0"))
+		})
+
+		It("should not report rules with the same priority and the same target", func() {
+			rules := compileRules(ctx, []devv1alpha1.RegistryRewriteRule{
+				newRule("a", devv1alpha1.Rule{Match: `^docker\.io/(.*)`, Replace: `mirror.local/$1`}),
+				newRule("b", devv1alpha1.Rule{Match: `^docker\.io/(.*)`, Replace: `mirror.local/$1`}),
+			}, nil)
+
+			Expect(detectConflicts(rules, DefaultSampleImages)).To(BeEmpty())
+		})
+
+		It("should not report rules whose conditions cannot overlap", func() {
+			rules := compileRules(ctx, []devv1alpha1.RegistryRewriteRule{
+				newRule("a", devv1alpha1.Rule{
+					Match:      `^docker\.io/(.*)`,
+					Replace:    `a.local/$1`,
+					Conditions: &devv1alpha1.RuleConditions{Labels: map[string]string{"team": "a"}},
+				}),
+				newRule("b", devv1alpha1.Rule{
+					Match:      `^docker\.io/(.*)`,
+					Replace:    `b.local/$1`,
+					Conditions: &devv1alpha1.RuleConditions{Labels: map[string]string{"team": "b"}},
+				}),
+			}, nil)
+
+			Expect(detectConflicts(rules, DefaultSampleImages)).To(BeEmpty())
+		})
+
+		It("should not report rules targeting different image sources", func() {
+			rules := compileRules(ctx, []devv1alpha1.RegistryRewriteRule{
+				newRule("volumes", devv1alpha1.Rule{
+					Match:    `^docker\.io/(.*)`,
+					Replace:  `artifacts.local/$1`,
+					Priority: 100,
+					Conditions: &devv1alpha1.RuleConditions{
+						ImageSources: []devv1alpha1.ImageSource{devv1alpha1.ImageSourceImageVolume},
+					},
+				}),
+				newRule("containers", devv1alpha1.Rule{
+					Match:   `^docker\.io/(.*)`,
+					Replace: `mirror.local/$1`,
+					Conditions: &devv1alpha1.RuleConditions{
+						ImageSources: []devv1alpha1.ImageSource{devv1alpha1.ImageSourceContainer},
+					},
+				}),
+			}, nil)
+
+			Expect(detectConflicts(rules, DefaultSampleImages)).To(BeEmpty())
+		})
+
+		It("should derive sample images from the rule patterns", func() {
+			rules := compileRules(ctx, []devv1alpha1.RegistryRewriteRule{
+				newRule("a", devv1alpha1.Rule{Match: `^internal\.example\.com/(.*)`, Replace: `a.local/$1`}),
+				newRule("b", devv1alpha1.Rule{Match: `^internal\.example\.com/team/(.*)`, Replace: `b.local/$1`}),
+			}, nil)
+
+			conflicts := detectConflicts(rules, DefaultSampleImages)
+			Expect(conflicts).To(HaveLen(1))
+			Expect(conflicts[0].kind).To(Equal(conflictAmbiguous))
+		})
+	})
+
+	Describe("RulesWatcher", func() {
+		It("should record warnings in the status of the affected resources", func() {
+			scheme := runtime.NewScheme()
+			Expect(corev1.AddToScheme(scheme)).To(Succeed())
+			Expect(devv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+			catchAll := newRule("catch-all", devv1alpha1.Rule{Match: `^docker\.io/(.*)`, Replace: `mirror.local/$1`, Priority: 100})
+			nginx := newRule("nginx", devv1alpha1.Rule{Match: `^docker\.io/library/nginx(.*)`, Replace: `nginx.local/nginx$1`})
+			unrelated := newRule("quay", devv1alpha1.Rule{Match: `^quay\.io/(.*)`, Replace: `mirror.local/quay/$1`})
+			c := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(&catchAll, &nginx, &unrelated).
+				WithStatusSubresource(&devv1alpha1.RegistryRewriteRule{}).
+				Build()
+
+			watcher := &RulesWatcher{Client: c, Mutator: &PodMutator{Client: c}}
+			for _, name := range []string{"catch-all", "nginx", "quay"} {
+				_, err := watcher.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: name}})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			updated := &devv1alpha1.RegistryRewriteRule{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "nginx"}, updated)).To(Succeed())
+			Expect(updated.Status.Warnings).To(HaveLen(1))
+			Expect(c.Get(ctx, types.NamespacedName{Name: "catch-all"}, updated)).To(Succeed())
+			Expect(updated.Status.Warnings).To(HaveLen(1))
+			Expect(c.Get(ctx, types.NamespacedName{Name: "quay"}, updated)).To(Succeed())
+			Expect(updated.Status.Warnings).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,148 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+// defaultEnvVarNames select the variables conventionally holding the images
+// launched by operators
+var defaultEnvVarNames = []string{"^RELATED_IMAGE_", "_IMAGE$"}
+
+// compileEnvVarNames compiles the patterns selecting the environment
+// variables rewritten by a rule, or returns nil when the rule doesn't rewrite
+// environment variables
+func compileEnvVarNames(rule devv1alpha1.Rule) ([]*regexp.Regexp, error) {
+	if rule.EnvVars == nil {
+		return nil, nil
+	}
+	patterns := rule.EnvVars.Names
+	if len(patterns) == 0 {
+		patterns = defaultEnvVarNames
+	}
+	names := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		name, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid environment variable name pattern %q: %w", pattern, err)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// envVarRules returns the rules rewriting the environment variable of the
+// given name
+func envVarRules(rules []compiledRule, name string) []compiledRule {
+	var selected []compiledRule
+	for _, rule := range rules {
+		for _, pattern := range rule.envNames {
+			if pattern.MatchString(name) {
+				selected = append(selected, rule)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// forEachContainerEnv calls fn with the source and the name of every
+// container, init container and ephemeral container of the pod, and a pointer
+// to each of its environment variables
+func forEachContainerEnv(pod *corev1.Pod, fn func(source devv1alpha1.ImageSource, container string, env *corev1.EnvVar)) {
+	for i := range pod.Spec.Containers {
+		c := &pod.Spec.Containers[i]
+		for j := range c.Env {
+			fn(devv1alpha1.ImageSourceContainer, c.Name, &c.Env[j])
+		}
+	}
+	for i := range pod.Spec.InitContainers {
+		c := &pod.Spec.InitContainers[i]
+		for j := range c.Env {
+			fn(devv1alpha1.ImageSourceInitContainer, c.Name, &c.Env[j])
+		}
+	}
+	for i := range pod.Spec.EphemeralContainers {
+		c := &pod.Spec.EphemeralContainers[i]
+		for j := range c.Env {
+			fn(devv1alpha1.ImageSourceEphemeralContainer, c.Name, &c.Env[j])
+		}
+	}
+}
+
+// isImageValue reports whether the value of an environment variable is an
+// image reference. Bare words such as true or 1 parse as Docker Hub images, so
+// the value must also have a registry, a slash, a tag or a digest.
+func isImageValue(value string) bool {
+	ref, err := parseReference(value)
+	if err != nil {
+		return false
+	}
+	return strings.Contains(ref.name(), "/") || ref.tag != "" || ref.digest != ""
+}
+
+// rewriteEnvVars rewrites the images held by the environment variables
+// selected by the rules, and returns the rewrites applied to the pod. Values
+// set with valueFrom, or not holding image references, are left alone.
+func (m *PodMutator) rewriteEnvVars(ctx context.Context, pod *corev1.Pod, rules []compiledRule) []imageRewrite {
+	logger := log.FromContext(ctx)
+
+	var rewrites []imageRewrite
+	forEachContainerEnv(pod, func(source devv1alpha1.ImageSource, container string, env *corev1.EnvVar) {
+		if env.ValueFrom != nil || env.Value == "" {
+			return
+		}
+		selected := envVarRules(rules, env.Name)
+		if len(selected) == 0 {
+			return
+		}
+		if !isImageValue(env.Value) {
+			return
+		}
+
+		rewrite, ok := m.rewriteSourceImage(ctx, source, env.Value, selected, pod, false)
+		if !ok {
+			return
+		}
+		kind := imageSourceKinds[source]
+		rewrite.container = container + "/" + env.Name
+		switch {
+		case rewrite.err != nil:
+			logger.Error(rewrite.err, "Rule produced an invalid "+kind+" environment variable image, keeping the original",
+				"container", container, "env", env.Name, "image", env.Value, "rule", ruleRef(rewrite.rule))
+		case rewrite.rewritten == normalizeImage(env.Value):
+		case rewrite.mode == devv1alpha1.RewriteModeAudit:
+			logger.Info("Audited "+kind+" environment variable image rewrite", "container", container, "env", env.Name,
+				"from", env.Value, "to", rewrite.rewritten, "rule", ruleRef(rewrite.rule))
+		default:
+			env.Value = rewrite.rewritten
+			rewrites = append(rewrites, rewrite)
+			logger.Info("Mutated "+kind+" environment variable image", "container", container, "env", env.Name,
+				"from", rewrite.original, "to", rewrite.rewritten, "rule", ruleRef(rewrite.rule))
+		}
+	})
+	return rewrites
+}
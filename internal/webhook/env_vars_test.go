@@ -0,0 +1,156 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var _ = Describe("Environment variable images", func() {
+	var (
+		ctx     context.Context
+		mutator *PodMutator
+		pod     *corev1.Pod
+	)
+
+	setup := func(rules ...devv1alpha1.Rule) {
+		mutator = newTestMutator(newRuleResource("operators", rules...))
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "operator", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name:  "manager",
+					Image: "quay.io/example/operator:v1.0.0",
+					Env: []corev1.EnvVar{
+						{Name: "RELATED_IMAGE_OPERAND", Value: "quay.io/example/operand:v1.0.0"},
+						{Name: "WEBHOOK_IMAGE", Value: "quay.io/example/webhook:v1.0.0"},
+						{Name: "LOG_LEVEL", Value: "debug"},
+						{Name: "SIDECAR_IMAGE", ValueFrom: &corev1.EnvVarSource{
+							ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "images"},
+								Key:                  "sidecar",
+							},
+						}},
+					},
+				}},
+			},
+		}
+	})
+
+	It("should rewrite the variables matching the default names", func() {
+		setup(devv1alpha1.Rule{
+			Match:   `^quay\.io/(.*)`,
+			Replace: "mirror.local/quay/$1",
+			EnvVars: &devv1alpha1.EnvVarRewrite{},
+		})
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/quay/example/operator:v1.0.0"))
+		Expect(patchValue(resp, "/spec/containers/0/env/0/value")).To(Equal("mirror.local/quay/example/operand:v1.0.0"))
+		Expect(patchValue(resp, "/spec/containers/0/env/1/value")).To(Equal("mirror.local/quay/example/webhook:v1.0.0"))
+		Expect(patchValue(resp, "/spec/containers/0/env/2/value")).To(BeNil())
+		Expect(patchValue(resp, "/spec/containers/0/env/3/valueFrom")).To(BeNil())
+
+		annotations, ok := patchValue(resp, "/metadata/annotations").(map[string]any)
+		Expect(ok).To(BeTrue())
+		Expect(annotations[OriginalEnvImagesAnnotation]).To(And(
+			ContainSubstring(`"manager/RELATED_IMAGE_OPERAND":{"original":"quay.io/example/operand:v1.0.0"`),
+			ContainSubstring(`"manager/WEBHOOK_IMAGE"`),
+		))
+	})
+
+	It("should not rewrite boolean and numeric values", func() {
+		setup(devv1alpha1.Rule{
+			Match:   `^docker\.io/(.*)`,
+			Replace: "mirror.local/$1",
+			EnvVars: &devv1alpha1.EnvVarRewrite{},
+		})
+		pod.Spec.Containers[0].Env = []corev1.EnvVar{
+			{Name: "PULL_CUSTOM_IMAGE", Value: "false"},
+			{Name: "RELATED_IMAGE_X", Value: "1"},
+			{Name: "RELATED_IMAGE_VERSION", Value: "1.0"},
+			{Name: "RELATED_IMAGE_BUSYBOX", Value: "busybox:1.36"},
+		}
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/env/0/value")).To(BeNil())
+		Expect(patchValue(resp, "/spec/containers/0/env/1/value")).To(BeNil())
+		Expect(patchValue(resp, "/spec/containers/0/env/2/value")).To(BeNil())
+		Expect(patchValue(resp, "/spec/containers/0/env/3/value")).To(Equal("mirror.local/library/busybox:1.36"))
+	})
+
+	It("should not rewrite the variables of existing pods", func() {
+		setup(devv1alpha1.Rule{
+			Match:   `^quay\.io/(.*)`,
+			Replace: "mirror.local/quay/$1",
+			EnvVars: &devv1alpha1.EnvVarRewrite{},
+		})
+
+		req := newPodRequest(pod)
+		req.Operation = admissionv1.Update
+		resp := mutator.Handle(ctx, req)
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/quay/example/operator:v1.0.0"))
+		Expect(patchValue(resp, "/spec/containers/0/env/0/value")).To(BeNil())
+		Expect(patchValue(resp, "/spec/containers/0/env/1/value")).To(BeNil())
+	})
+
+	It("should only rewrite the variables matching the configured names", func() {
+		setup(devv1alpha1.Rule{
+			Match:   `^quay\.io/(.*)`,
+			Replace: "mirror.local/quay/$1",
+			EnvVars: &devv1alpha1.EnvVarRewrite{Names: []string{"^WEBHOOK_"}},
+		})
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/env/0/value")).To(BeNil())
+		Expect(patchValue(resp, "/spec/containers/0/env/1/value")).To(Equal("mirror.local/quay/example/webhook:v1.0.0"))
+	})
+
+	It("should not rewrite variables unless enabled", func() {
+		setup(devv1alpha1.Rule{Match: `^quay\.io/(.*)`, Replace: "mirror.local/quay/$1"})
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/quay/example/operator:v1.0.0"))
+		Expect(patchValue(resp, "/spec/containers/0/env/0/value")).To(BeNil())
+		Expect(patchValue(resp, "/spec/containers/0/env/1/value")).To(BeNil())
+	})
+
+	It("should leave the variables unchanged for rules in audit mode", func() {
+		setup(devv1alpha1.Rule{
+			Match:   `^quay\.io/example/operand(.*)`,
+			Replace: "mirror.local/operand$1",
+			Mode:    devv1alpha1.RewriteModeAudit,
+			EnvVars: &devv1alpha1.EnvVarRewrite{},
+		})
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(resp.Patches).To(BeEmpty())
+	})
+})
@@ -0,0 +1,286 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/flowcontrol"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+// Event reasons emitted by the webhook
+const (
+	// ReasonImageRewritten is the reason of events describing rewritten images
+	ReasonImageRewritten = "ImageRewritten"
+	// ReasonInvalidRewrite is the reason of events describing rules that
+	// produced an invalid image reference
+	ReasonInvalidRewrite = "InvalidRewrite"
+)
+
+const (
+	// eventAction is the action of the events emitted by the webhook
+	eventAction = "Rewrite"
+	// podCreationTimeout bounds the wait for a pod to be created before
+	// emitting its events
+	podCreationTimeout = 10 * time.Second
+)
+
+// DefaultPendingPodEvents is the default number of pods whose creation is
+// waited for at once before emitting their events
+const DefaultPendingPodEvents = 100
+
+// +kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get
+
+// EventLimiter limits the number of events emitted by the webhook, so that a
+// large rollout doesn't flood etcd. Events about the same object are emitted
+// at most once per interval.
+type EventLimiter struct {
+	mu       sync.Mutex
+	bucket   flowcontrol.PassiveRateLimiter
+	interval time.Duration
+	seen     map[string]time.Time
+}
+
+// NewEventLimiter returns an EventLimiter allowing qps events per second with
+// the given burst, and one event per object and reason per interval
+func NewEventLimiter(qps float32, burst int, interval time.Duration) *EventLimiter {
+	return &EventLimiter{
+		bucket:   flowcontrol.NewTokenBucketPassiveRateLimiter(qps, burst),
+		interval: interval,
+		seen:     map[string]time.Time{},
+	}
+}
+
+// allow reports whether an event identified by key may be emitted now
+func (l *EventLimiter) allow(key string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if last, ok := l.seen[key]; ok && now.Sub(last) < l.interval {
+		return false
+	}
+	if !l.bucket.TryAccept() {
+		return false
+	}
+
+	// Forget expired entries to keep the map bounded
+	for k, last := range l.seen {
+		if now.Sub(last) >= l.interval {
+			delete(l.seen, k)
+		}
+	}
+	l.seen[key] = now
+	return true
+}
+
+// pendingEvents are the events of a pod waiting for its creation
+type pendingEvents struct {
+	key      types.NamespacedName
+	deadline time.Time
+	emit     func(regarding *corev1.ObjectReference)
+}
+
+// PodEventQueue emits the events of pods without owner once they are
+// created, since events must reference the UID of their object. It waits for
+// a bounded number of pods at once, dropping the events of pods admitted while
+// it is full, and stops waiting when the manager stops. It runs on every
+// replica, since each one serves admission requests.
+type PodEventQueue struct {
+	reader  client.Reader
+	workers int
+	pending chan pendingEvents
+}
+
+// NewPodEventQueue returns a PodEventQueue waiting for at most size pods at
+// once, or DefaultPendingPodEvents when zero, and queueing as many
+func NewPodEventQueue(reader client.Reader, size int) *PodEventQueue {
+	if size <= 0 {
+		size = DefaultPendingPodEvents
+	}
+	return &PodEventQueue{
+		reader:  reader,
+		workers: size,
+		pending: make(chan pendingEvents, size),
+	}
+}
+
+// Start waits for the queued pods until the context is done
+func (q *PodEventQueue) Start(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case p := <-q.pending:
+					q.wait(ctx, p)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// NeedLeaderElection returns false, since every replica serves pods
+func (q *PodEventQueue) NeedLeaderElection() bool {
+	return false
+}
+
+// enqueue queues the events of a pod, and reports whether the queue had room
+// for them. A nil PodEventQueue drops the events.
+func (q *PodEventQueue) enqueue(key types.NamespacedName, emit func(regarding *corev1.ObjectReference)) bool {
+	if q == nil {
+		return false
+	}
+	select {
+	case q.pending <- pendingEvents{key: key, deadline: time.Now().Add(podCreationTimeout), emit: emit}:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait waits for the creation of a pod until its deadline, and emits its
+// events
+func (q *PodEventQueue) wait(ctx context.Context, p pendingEvents) {
+	ctx, cancel := context.WithDeadline(ctx, p.deadline)
+	defer cancel()
+
+	created := &corev1.Pod{}
+	err := wait.PollUntilContextCancel(ctx, time.Second, false, func(ctx context.Context) (bool, error) {
+		if err := q.reader.Get(ctx, p.key, created); err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+		return true, nil
+	})
+	if err != nil {
+		log.FromContext(ctx).V(1).Info("Pod not found, skipping events", "pod", p.key, "error", err.Error())
+		return
+	}
+	p.emit(podReference(created))
+}
+
+// emitEvents emits a Normal event describing the enforced rewrites and a
+// Warning event describing the failed ones. Events are emitted on the owning
+// workload when there is one, otherwise on the pod once it is created, through
+// the PodEventQueue. Dry-run requests never emit events, as declared by
+// sideEffects=NoneOnDryRun.
+func (m *PodMutator) emitEvents(
+	ctx context.Context, req admission.Request, pod *corev1.Pod, rewrites, failed []imageRewrite,
+) {
+	if m.Recorder == nil || (req.DryRun != nil && *req.DryRun) {
+		return
+	}
+
+	var changed []string
+	for _, r := range rewrites {
+		if r.mode == devv1alpha1.RewriteModeAudit {
+			continue
+		}
+		changed = append(changed, fmt.Sprintf("%s (%s, rule %s)", r.container, r.rewritten, ruleRef(r.rule)))
+	}
+	var invalid []string
+	for _, r := range failed {
+		invalid = append(invalid, fmt.Sprintf("%s (rule %s: %v)", r.container, ruleRef(r.rule), r.err))
+	}
+	if len(changed) == 0 && len(invalid) == 0 {
+		return
+	}
+
+	podName := pod.Name
+	if podName == "" {
+		podName = pod.GenerateName
+	}
+	emit := func(regarding *corev1.ObjectReference) {
+		if len(changed) > 0 && m.EventLimiter.allow(string(regarding.UID)+"/"+ReasonImageRewritten) {
+			m.Recorder.Eventf(regarding, nil, corev1.EventTypeNormal, ReasonImageRewritten, eventAction,
+				"Rewrote images of pod %s: %s", podName, strings.Join(changed, ", "))
+		}
+		if len(invalid) > 0 && m.EventLimiter.allow(string(regarding.UID)+"/"+ReasonInvalidRewrite) {
+			m.Recorder.Eventf(regarding, nil, corev1.EventTypeWarning, ReasonInvalidRewrite, eventAction,
+				"Kept original images of pod %s: %s", podName, strings.Join(invalid, ", "))
+		}
+	}
+
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		emit(&corev1.ObjectReference{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Namespace:  req.Namespace,
+			Name:       owner.Name,
+			UID:        owner.UID,
+		})
+		return
+	}
+
+	if pod.UID != "" {
+		emit(podReference(pod))
+		return
+	}
+	if pod.Name == "" {
+		// Pods created with generateName can't be found once created
+		return
+	}
+
+	// The pod doesn't exist yet, wait for its creation to reference its UID
+	key := types.NamespacedName{Namespace: req.Namespace, Name: pod.Name}
+	if !m.PodEvents.enqueue(key, emit) {
+		log.FromContext(ctx).V(1).Info("Pod event queue unavailable or full, skipping events", "pod", key)
+	}
+}
+
+// apiReader returns the reader used to look up created pods
+func (m *PodMutator) apiReader() client.Reader {
+	if m.APIReader != nil {
+		return m.APIReader
+	}
+	return m.Client
+}
+
+// podReference returns a reference to the pod
+func podReference(pod *corev1.Pod) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  pod.Namespace,
+		Name:       pod.Name,
+		UID:        pod.UID,
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var _ = Describe("Rewrite events", func() {
+	var (
+		mutator  *PodMutator
+		recorder *events.FakeRecorder
+		pod      *corev1.Pod
+		ctx      context.Context
+	)
+
+	newMutator := func(objects ...client.Object) {
+		mutator = newTestMutator(append(objects, newRuleResource("dockerhub",
+			devv1alpha1.Rule{Match: `^docker\.io/library/broken(.*)`, Replace: ``, Priority: 10},
+			devv1alpha1.Rule{Match: `^docker\.io/(.*)`, Replace: `mirror.local/$1`},
+		))...)
+		recorder = events.NewFakeRecorder(10)
+		mutator.Recorder = recorder
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		newMutator()
+		controller := true
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "web-",
+				Namespace:    "default",
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "apps/v1",
+					Kind:       "ReplicaSet",
+					Name:       "web-5d4f",
+					UID:        "rs-uid",
+					Controller: &controller,
+				}},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Image: "nginx:1.25"}},
+			},
+		}
+	})
+
+	It("should emit a Normal event on the owning workload", func() {
+		mutator.Handle(ctx, newPodRequest(pod))
+		Expect(recorder.Events).To(Receive(Equal(
+			"Normal ImageRewritten Rewrote images of pod web-: app (mirror.local/library/nginx:1.25, rule dockerhub/1)")))
+	})
+
+	It("should emit a Warning event when a rule produces an invalid image", func() {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "broken", Image: "broken:1.0"})
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/1/image")).To(BeNil())
+		Expect(recorder.Events).To(Receive(HavePrefix("Normal ImageRewritten")))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning InvalidRewrite Kept original images of pod web-: broken")))
+	})
+
+	It("should not emit events for dry-run requests", func() {
+		dryRun := true
+		req := newPodRequest(pod)
+		req.DryRun = &dryRun
+
+		mutator.Handle(ctx, req)
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("should rate limit events about the same object", func() {
+		mutator.EventLimiter = NewEventLimiter(100, 100, time.Minute)
+
+		mutator.Handle(ctx, newPodRequest(pod))
+		mutator.Handle(ctx, newPodRequest(pod))
+		Expect(recorder.Events).To(Receive())
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("should emit the event on a bare pod once it is created", func() {
+		pod.GenerateName = ""
+		pod.Name = "bare"
+		pod.OwnerReferences = nil
+		created := pod.DeepCopy()
+		created.UID = "pod-uid"
+		newMutator(created)
+		mutator.PodEvents = NewPodEventQueue(mutator.apiReader(), 1)
+		queueCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			defer GinkgoRecover()
+			Expect(mutator.PodEvents.Start(queueCtx)).To(Succeed())
+		}()
+
+		mutator.Handle(ctx, newPodRequest(pod))
+		Eventually(recorder.Events).WithTimeout(podCreationTimeout).
+			Should(Receive(HavePrefix("Normal ImageRewritten Rewrote images of pod bare")))
+	})
+
+	It("should drop the events of bare pods when the queue is full", func() {
+		queue := NewPodEventQueue(mutator.apiReader(), 1)
+		emit := func(*corev1.ObjectReference) {}
+		Expect(queue.enqueue(types.NamespacedName{Namespace: "default", Name: "first"}, emit)).To(BeTrue())
+		Expect(queue.enqueue(types.NamespacedName{Namespace: "default", Name: "second"}, emit)).To(BeFalse())
+
+		var unset *PodEventQueue
+		Expect(unset.enqueue(types.NamespacedName{Namespace: "default", Name: "first"}, emit)).To(BeFalse())
+	})
+})
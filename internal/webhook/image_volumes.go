@@ -0,0 +1,62 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+// rewriteImageVolumes rewrites the references of the image volumes of the pod
+// and returns the rewrites applied to the pod, keyed by volume name
+func (m *PodMutator) rewriteImageVolumes(ctx context.Context, pod *corev1.Pod, rules []compiledRule) []imageRewrite {
+	logger := log.FromContext(ctx)
+
+	var rewrites []imageRewrite
+	for i := range pod.Spec.Volumes {
+		volume := &pod.Spec.Volumes[i]
+		if volume.Image == nil || volume.Image.Reference == "" {
+			continue
+		}
+		reference := volume.Image.Reference
+
+		rewrite, ok := m.rewriteSourceImage(ctx, devv1alpha1.ImageSourceImageVolume, reference, rules, pod, false)
+		if !ok {
+			continue
+		}
+		rewrite.container = volume.Name
+		switch {
+		case rewrite.err != nil:
+			logger.Error(rewrite.err, "Rule produced an invalid image volume reference, keeping the original",
+				"volume", volume.Name, "image", reference, "rule", ruleRef(rewrite.rule))
+		case rewrite.rewritten == normalizeImage(reference):
+		case rewrite.mode == devv1alpha1.RewriteModeAudit:
+			logger.Info("Audited image volume rewrite", "volume", volume.Name, "from", reference,
+				"to", rewrite.rewritten, "rule", ruleRef(rewrite.rule))
+		default:
+			volume.Image.Reference = rewrite.rewritten
+			rewrites = append(rewrites, rewrite)
+			logger.Info("Mutated image volume", "volume", volume.Name, "from", reference, "to", rewrite.rewritten,
+				"rule", ruleRef(rewrite.rule))
+		}
+	}
+	return rewrites
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var _ = Describe("Image volumes", func() {
+	var (
+		ctx     context.Context
+		mutator *PodMutator
+		pod     *corev1.Pod
+	)
+
+	setup := func(rules ...devv1alpha1.Rule) {
+		mutator = newTestMutator(newRuleResource("artifacts", rules...))
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "model-server", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "server", Image: "ghcr.io/example/server:v1"}},
+				Volumes: []corev1.Volume{
+					{Name: "model", VolumeSource: corev1.VolumeSource{
+						Image: &corev1.ImageVolumeSource{Reference: "ghcr.io/example/model:v2", PullPolicy: corev1.PullIfNotPresent},
+					}},
+					{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+				},
+			},
+		}
+	})
+
+	It("should rewrite the references of image volumes", func() {
+		setup(devv1alpha1.Rule{
+			Match:            `^ghcr\.io/(.*)`,
+			Replace:          "mirror.local/ghcr/$1",
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "mirror-creds"}},
+		})
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/ghcr/example/server:v1"))
+		Expect(patchValue(resp, "/spec/volumes/0/image/reference")).To(Equal("mirror.local/ghcr/example/model:v2"))
+		Expect(patchValue(resp, "/spec/imagePullSecrets")).To(ConsistOf(HaveKeyWithValue("name", "mirror-creds")))
+
+		annotations, ok := patchValue(resp, "/metadata/annotations").(map[string]any)
+		Expect(ok).To(BeTrue())
+		Expect(annotations[OriginalVolumeImagesAnnotation]).To(ContainSubstring(
+			`"model":{"original":"ghcr.io/example/model:v2","rewritten":"mirror.local/ghcr/example/model:v2"`))
+		Expect(annotations[OriginalImagesAnnotation]).NotTo(ContainSubstring("model"))
+	})
+
+	It("should only rewrite image volumes when targeted by the conditions", func() {
+		setup(devv1alpha1.Rule{
+			Match:   `^ghcr\.io/(.*)`,
+			Replace: "artifacts.local/$1",
+			Conditions: &devv1alpha1.RuleConditions{
+				ImageSources: []devv1alpha1.ImageSource{devv1alpha1.ImageSourceImageVolume},
+			},
+		})
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(BeNil())
+		Expect(patchValue(resp, "/spec/volumes/0/image/reference")).To(Equal("artifacts.local/example/model:v2"))
+	})
+
+	It("should leave image volumes alone for rules targeting containers", func() {
+		setup(devv1alpha1.Rule{
+			Match:   `^ghcr\.io/(.*)`,
+			Replace: "mirror.local/ghcr/$1",
+			Conditions: &devv1alpha1.RuleConditions{
+				ImageSources: []devv1alpha1.ImageSource{devv1alpha1.ImageSourceContainer},
+			},
+		})
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/ghcr/example/server:v1"))
+		Expect(patchValue(resp, "/spec/volumes/0/image/reference")).To(BeNil())
+	})
+
+	It("should not rewrite the image volumes of existing pods", func() {
+		setup(devv1alpha1.Rule{Match: `^ghcr\.io/(.*)`, Replace: "mirror.local/ghcr/$1"})
+
+		req := newPodRequest(pod)
+		req.Operation = admissionv1.Update
+		resp := mutator.Handle(ctx, req)
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/ghcr/example/server:v1"))
+		Expect(patchValue(resp, "/spec/volumes/0/image/reference")).To(BeNil())
+	})
+})
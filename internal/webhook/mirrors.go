@@ -0,0 +1,236 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+	"github.com/flemzord/mutating-registry-webhook/internal/registry"
+)
+
+// DefaultProbeInterval is the default interval between two probes of the
+// target registries
+const DefaultProbeInterval = 30 * time.Second
+
+var mirrorUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "registry_rewriter_mirror_up",
+	Help: "Whether a target registry of the rules answered the last probe (1) or not (0)",
+}, []string{"registry"})
+
+func init() {
+	metrics.Registry.MustRegister(mirrorUp)
+}
+
+// MirrorProber periodically probes the target registries of the rules with a
+// GET /v2/ request and tracks their health. It runs on every replica, since
+// each one serves admission requests.
+type MirrorProber struct {
+	client   client.Client
+	registry *registry.Client
+	interval time.Duration
+
+	mu      sync.RWMutex
+	healthy map[string]bool
+	changes chan event.GenericEvent
+}
+
+// NewMirrorProber returns a MirrorProber probing the targets of the rules
+// every interval, or DefaultProbeInterval when zero
+func NewMirrorProber(c client.Client, registryClient *registry.Client, interval time.Duration) *MirrorProber {
+	if interval == 0 {
+		interval = DefaultProbeInterval
+	}
+	return &MirrorProber{
+		client:   c,
+		registry: registryClient,
+		interval: interval,
+		healthy:  map[string]bool{},
+		changes:  make(chan event.GenericEvent, 1),
+	}
+}
+
+// Start probes the targets until the context is done
+func (p *MirrorProber) Start(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if err := p.probe(ctx); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to probe target registries")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (p *MirrorProber) NeedLeaderElection() bool {
+	return false
+}
+
+// Changes returns a channel receiving an event whenever the health of a
+// target changes
+func (p *MirrorProber) Changes() <-chan event.GenericEvent {
+	return p.changes
+}
+
+// Healthy reports whether a registry answered its last probe. Registries not
+// probed yet are considered healthy.
+func (p *MirrorProber) Healthy(registry string) bool {
+	if p == nil {
+		return true
+	}
+	healthy, ok := p.status(registry)
+	return healthy || !ok
+}
+
+// status returns the health of a registry and whether it was probed
+func (p *MirrorProber) status(registry string) (bool, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	healthy, ok := p.healthy[registry]
+	return healthy, ok
+}
+
+// probe probes every target of the rules concurrently
+func (p *MirrorProber) probe(ctx context.Context) error {
+	ruleList := &devv1alpha1.RegistryRewriteRuleList{}
+	if err := p.client.List(ctx, ruleList); err != nil {
+		return fmt.Errorf("failed to list RegistryRewriteRule: %w", err)
+	}
+	// Targets are probed over plain HTTP when one of the rules marks them
+	// insecure
+	targets := map[string]bool{}
+	for _, rr := range ruleList.Items {
+		for _, rule := range rr.Spec.Rules {
+			for _, target := range rule.Targets {
+				targets[target.Registry] = targets[target.Registry] || target.Insecure
+			}
+		}
+	}
+
+	logger := log.FromContext(ctx)
+	results := make(map[string]bool, len(targets))
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for target, insecure := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.registry.Ping(ctx, target, insecure)
+			if err != nil {
+				logger.V(1).Info("Target registry is unhealthy", "registry", target, "error", err.Error())
+			}
+			mu.Lock()
+			results[target] = err == nil
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	changed := len(results) != len(p.healthy)
+	for target, healthy := range p.healthy {
+		if previous, ok := results[target]; !ok || previous != healthy {
+			changed = true
+		}
+		if _, ok := results[target]; !ok {
+			mirrorUp.DeleteLabelValues(target)
+		}
+	}
+	p.healthy = results
+	p.mu.Unlock()
+
+	for target, healthy := range results {
+		value := 0.0
+		if healthy {
+			value = 1
+		}
+		mirrorUp.WithLabelValues(target).Set(value)
+	}
+
+	if changed {
+		select {
+		case p.changes <- event.GenericEvent{Object: &devv1alpha1.RegistryRewriteRule{}}:
+		default:
+			// A change is already pending, and re-enqueues all rules
+		}
+	}
+	return nil
+}
+
+// mirrorsCondition returns the MirrorsHealthy condition of a resource, or
+// false when its rules have no targets
+func (p *MirrorProber) mirrorsCondition(rr *devv1alpha1.RegistryRewriteRule) (metav1.Condition, bool) {
+	seen := map[string]bool{}
+	var unhealthy, unknown []string
+	for _, rule := range rr.Spec.Rules {
+		for _, target := range rule.Targets {
+			if seen[target.Registry] {
+				continue
+			}
+			seen[target.Registry] = true
+			switch healthy, ok := p.status(target.Registry); {
+			case !ok:
+				unknown = append(unknown, target.Registry)
+			case !healthy:
+				unhealthy = append(unhealthy, target.Registry)
+			}
+		}
+	}
+	if len(seen) == 0 {
+		return metav1.Condition{}, false
+	}
+	sort.Strings(unhealthy)
+	sort.Strings(unknown)
+
+	condition := metav1.Condition{
+		Type:               devv1alpha1.ConditionMirrorsHealthy,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: rr.Generation,
+		Reason:             "Healthy",
+		Message:            "All target registries are healthy",
+	}
+	switch {
+	case len(unhealthy) > 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Unhealthy"
+		condition.Message = "Unhealthy target registries: " + strings.Join(unhealthy, ", ")
+	case len(unknown) > 0:
+		condition.Status = metav1.ConditionUnknown
+		condition.Reason = "Probing"
+		condition.Message = "Target registries not probed yet: " + strings.Join(unknown, ", ")
+	}
+	return condition, true
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"regexp"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+	"github.com/flemzord/mutating-registry-webhook/internal/registry"
+	"github.com/flemzord/mutating-registry-webhook/internal/registry/registrytest"
+)
+
+var _ = Describe("Fallback mirrors", func() {
+	var (
+		primary, fallback *registrytest.Server
+		rule              devv1alpha1.Rule
+		c                 client.Client
+		prober            *MirrorProber
+		mutator           *PodMutator
+		pod               *corev1.Pod
+		ctx               context.Context
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		primary = registrytest.NewServer()
+		fallback = registrytest.NewServer()
+
+		rule = devv1alpha1.Rule{
+			Match:   `^docker\.io/(.*)`,
+			Replace: `mirror.local/dockerhub/$1`,
+			Targets: []devv1alpha1.Target{{Registry: primary.Host()}, {Registry: fallback.Host()}},
+		}
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(devv1alpha1.AddToScheme(scheme)).To(Succeed())
+		c = fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(&devv1alpha1.RegistryRewriteRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "mirrors"},
+				Spec:       devv1alpha1.RegistryRewriteRuleSpec{Rules: []devv1alpha1.Rule{rule}},
+			}).
+			WithStatusSubresource(&devv1alpha1.RegistryRewriteRule{}).
+			Build()
+
+		prober = NewMirrorProber(c, &registry.Client{HTTPClient: primary.Client()}, 0)
+		mutator = &PodMutator{Client: c, Mirrors: prober}
+		pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"}}
+	})
+
+	AfterEach(func() {
+		primary.Close()
+		fallback.Close()
+	})
+
+	rules := func() []compiledRule {
+		return []compiledRule{{rule: rule, regex: regexp.MustCompile(rule.Match), replace: rule.Replace}}
+	}
+
+	It("should rewrite to the first target", func() {
+		Expect(prober.probe(ctx)).To(Succeed())
+		Expect(mutator.mutateImage(ctx, "nginx:1.25", rules(), pod)).
+			To(Equal(primary.Host() + "/dockerhub/library/nginx:1.25"))
+	})
+
+	It("should fall back to the next healthy target", func() {
+		primary.SetDown(true)
+		Expect(prober.probe(ctx)).To(Succeed())
+		Expect(prober.Healthy(primary.Host())).To(BeFalse())
+		Expect(mutator.mutateImage(ctx, "nginx:1.25", rules(), pod)).
+			To(Equal(fallback.Host() + "/dockerhub/library/nginx:1.25"))
+	})
+
+	It("should use the first target when none is healthy", func() {
+		primary.SetDown(true)
+		fallback.SetDown(true)
+		Expect(prober.probe(ctx)).To(Succeed())
+		Expect(mutator.mutateImage(ctx, "nginx:1.25", rules(), pod)).
+			To(Equal(primary.Host() + "/dockerhub/library/nginx:1.25"))
+	})
+
+	It("should probe insecure targets over plain HTTP", func() {
+		cache := registrytest.NewInsecureServer()
+		defer cache.Close()
+		rule.Targets = []devv1alpha1.Target{{Registry: cache.Host(), Insecure: true}, {Registry: fallback.Host()}}
+		rr := &devv1alpha1.RegistryRewriteRule{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "mirrors"}, rr)).To(Succeed())
+		rr.Spec.Rules = []devv1alpha1.Rule{rule}
+		Expect(c.Update(ctx, rr)).To(Succeed())
+
+		Expect(prober.probe(ctx)).To(Succeed())
+		Expect(prober.Healthy(cache.Host())).To(BeTrue())
+		Expect(mutator.mutateImage(ctx, "nginx:1.25", rules(), pod)).
+			To(Equal(cache.Host() + "/dockerhub/library/nginx:1.25"))
+	})
+
+	It("should notify health changes", func() {
+		Expect(prober.probe(ctx)).To(Succeed())
+		Expect(prober.Changes()).To(Receive())
+		Expect(prober.probe(ctx)).To(Succeed())
+		Expect(prober.Changes()).NotTo(Receive())
+
+		primary.SetDown(true)
+		Expect(prober.probe(ctx)).To(Succeed())
+		Expect(prober.Changes()).To(Receive())
+	})
+
+	It("should report the health of the targets as a condition", func() {
+		watcher := &RulesWatcher{Client: c, Mutator: mutator, Prober: prober}
+		key := types.NamespacedName{Name: "mirrors"}
+		updated := &devv1alpha1.RegistryRewriteRule{}
+
+		_, err := watcher.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Get(ctx, key, updated)).To(Succeed())
+		Expect(meta.IsStatusConditionPresentAndEqual(updated.Status.Conditions,
+			devv1alpha1.ConditionMirrorsHealthy, metav1.ConditionUnknown)).To(BeTrue())
+
+		primary.SetDown(true)
+		Expect(prober.probe(ctx)).To(Succeed())
+		_, err = watcher.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Get(ctx, key, updated)).To(Succeed())
+		condition := meta.FindStatusCondition(updated.Status.Conditions, devv1alpha1.ConditionMirrorsHealthy)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Message).To(ContainSubstring(primary.Host()))
+	})
+})
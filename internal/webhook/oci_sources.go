@@ -0,0 +1,203 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+// OriginalOCISourcesAnnotation records, as a JSON map of field path to
+// ImageRecord, the OCI artifact references of a GitOps source rewritten by the
+// webhook
+const OriginalOCISourcesAnnotation = "dev.flemzord.fr/original-oci-sources"
+
+// ociScheme is the scheme of OCI repository URLs
+const ociScheme = "oci://"
+
+// Kinds of the GitOps sources pulling OCI artifacts
+var (
+	fluxOCIRepository  = schema.GroupKind{Group: "source.toolkit.fluxcd.io", Kind: "OCIRepository"}
+	fluxHelmRepository = schema.GroupKind{Group: "source.toolkit.fluxcd.io", Kind: "HelmRepository"}
+	argoApplication    = schema.GroupKind{Group: "argoproj.io", Kind: "Application"}
+)
+
+// OCISourceMutator rewrites the OCI artifact references of Flux OCIRepository
+// and HelmRepository resources, and Argo CD Application sources, with the
+// rules of the PodMutator
+type OCISourceMutator struct {
+	Mutator *PodMutator
+	// Disabled allows every request unchanged, for clusters that don't
+	// rewrite GitOps sources
+	Disabled bool
+}
+
+// +kubebuilder:webhook:path=/mutate-oci-sources,mutating=true,failurePolicy=ignore,groups=source.toolkit.fluxcd.io;argoproj.io,resources=ocirepositories;helmrepositories;applications,verbs=create;update,versions=v1;v1beta2;v1alpha1,name=mocisource.dev.flemzord.fr,admissionReviewVersions=v1,sideEffects=None
+
+// Handle handles GitOps source admission requests
+func (m *OCISourceMutator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if m.Disabled {
+		return admission.Allowed("OCI source rewrites disabled")
+	}
+	logger := log.FromContext(ctx)
+
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(req.Object.Raw); err != nil {
+		logger.Error(err, "Failed to decode object")
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if obj.GetAnnotations()[RewriteDisabledAnnotation] == "true" {
+		return admission.Allowed("rewrite disabled")
+	}
+
+	ruleSet, err := m.Mutator.getRules(ctx)
+	if err != nil {
+		logger.Error(err, "Failed to get rules")
+		return admission.Allowed("failed to get rules")
+	}
+	if len(ruleSet.rules) == 0 {
+		return admission.Allowed("no rules configured")
+	}
+
+	// Conditions are checked against the namespace and labels of the source
+	subject := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      obj.GetName(),
+		Namespace: obj.GetNamespace(),
+		Labels:    obj.GetLabels(),
+	}}
+	kind := obj.GroupVersionKind().Kind
+
+	var rewrites []imageRewrite
+	forEachOCISource(obj, func(field, url string) string {
+		reference := strings.TrimSuffix(strings.TrimPrefix(url, ociScheme), "/")
+		rewrite, ok := m.Mutator.rewriteSourceImage(ctx, devv1alpha1.ImageSourceOCIArtifact, reference,
+			ruleSet.rules, subject, false)
+		switch {
+		case !ok:
+			return url
+		case rewrite.err != nil:
+			logger.Error(rewrite.err, "Rule produced an invalid OCI artifact reference, keeping the original",
+				"kind", kind, "field", field, "url", url, "rule", ruleRef(rewrite.rule))
+			return url
+		case rewrite.rewritten == normalizeOCISource(reference):
+			return url
+		case rewrite.mode == devv1alpha1.RewriteModeAudit:
+			logger.Info("Audited OCI source rewrite", "kind", kind, "field", field, "from", url,
+				"to", rewrite.rewritten, "rule", ruleRef(rewrite.rule))
+			return url
+		}
+
+		rewrite.container = field
+		rewrite.original = url
+		if strings.HasPrefix(url, ociScheme) {
+			rewrite.rewritten = ociScheme + rewrite.rewritten
+		}
+		rewrites = append(rewrites, rewrite)
+		logger.Info("Mutated OCI source", "kind", kind, "field", field, "from", url, "to", rewrite.rewritten,
+			"rule", ruleRef(rewrite.rule))
+		return rewrite.rewritten
+	})
+
+	if len(rewrites) == 0 {
+		return admission.Allowed("no mutations needed")
+	}
+	if err := annotateImageRecords(obj, OriginalOCISourcesAnnotation, rewrites); err != nil {
+		logger.Error(err, "Failed to annotate object with rewrites")
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	marshaled, err := json.Marshal(obj)
+	if err != nil {
+		logger.Error(err, "Failed to marshal mutated object")
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// forEachOCISource calls fn with the path and the URL of every field of a
+// GitOps source referencing an OCI repository, and sets the field to the
+// returned URL
+func forEachOCISource(obj *unstructured.Unstructured, fn func(field, url string) string) {
+	spec, ok := obj.Object["spec"].(map[string]any)
+	if !ok {
+		return
+	}
+
+	switch obj.GroupVersionKind().GroupKind() {
+	case fluxOCIRepository:
+		rewriteField(spec, "url", "spec.url", fn)
+	case fluxHelmRepository:
+		if spec["type"] == "oci" {
+			rewriteField(spec, "url", "spec.url", fn)
+		}
+	case argoApplication:
+		if source, ok := spec["source"].(map[string]any); ok && isArgoOCISource(source) {
+			rewriteField(source, "repoURL", "spec.source.repoURL", fn)
+		}
+		sources, _ := spec["sources"].([]any)
+		for i := range sources {
+			if source, ok := sources[i].(map[string]any); ok && isArgoOCISource(source) {
+				rewriteField(source, "repoURL", fmt.Sprintf("spec.sources[%d].repoURL", i), fn)
+			}
+		}
+	}
+}
+
+// isArgoOCISource reports whether an Argo CD application source pulls an OCI
+// artifact: either an oci:// repository, or a Helm chart from a repository
+// without scheme, which Argo CD pulls from an OCI registry
+func isArgoOCISource(source map[string]any) bool {
+	url, _ := source["repoURL"].(string)
+	if strings.HasPrefix(url, ociScheme) {
+		return true
+	}
+	chart, _ := source["chart"].(string)
+	return chart != "" && url != "" && !strings.Contains(url, "://")
+}
+
+// rewriteField sets the string field key of m to the value returned by fn
+func rewriteField(m map[string]any, key, field string, fn func(field, url string) string) {
+	url, ok := m[key].(string)
+	if !ok || url == "" {
+		return
+	}
+	m[key] = fn(field, url)
+}
+
+// normalizeOCISource adds the docker.io prefix to OCI artifact references
+// without registry. Unlike images, single-segment Docker Hub references are
+// kept as they are, since sources such as oci://docker.io/bitnamicharts
+// reference a repository prefix rather than an official image.
+func normalizeOCISource(reference string) string {
+	host, _, found := strings.Cut(reference, "/")
+	if found && isDomain(host) {
+		return reference
+	}
+	return "docker.io/" + reference
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var _ = Describe("OCI sources", func() {
+	var (
+		ctx     context.Context
+		mutator *OCISourceMutator
+	)
+
+	setup := func(rules ...devv1alpha1.Rule) {
+		mutator = &OCISourceMutator{Mutator: newTestMutator(newRuleResource("charts", rules...))}
+	}
+
+	newSource := func(apiVersion, kind string, spec map[string]any) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": apiVersion,
+			"kind":       kind,
+			"metadata":   map[string]any{"name": "podinfo", "namespace": "flux-system"},
+			"spec":       spec,
+		}}
+	}
+
+	newRequest := func(obj *unstructured.Unstructured) admission.Request {
+		raw, err := obj.MarshalJSON()
+		Expect(err).NotTo(HaveOccurred())
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Namespace: obj.GetNamespace(),
+			Object:    runtime.RawExtension{Raw: raw},
+		}}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		setup(devv1alpha1.Rule{Match: `^ghcr\.io/(.*)`, Replace: "mirror.local/ghcr/$1"})
+	})
+
+	It("should rewrite the URL of Flux OCIRepository resources", func() {
+		source := newSource("source.toolkit.fluxcd.io/v1", "OCIRepository", map[string]any{
+			"url": "oci://ghcr.io/stefanprodan/manifests/podinfo",
+			"ref": map[string]any{"tag": "latest"},
+		})
+
+		resp := mutator.Handle(ctx, newRequest(source))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(patchValue(resp, "/spec/url")).To(Equal("oci://mirror.local/ghcr/stefanprodan/manifests/podinfo"))
+		annotations, ok := patchValue(resp, "/metadata/annotations").(map[string]any)
+		Expect(ok).To(BeTrue())
+		Expect(annotations[OriginalOCISourcesAnnotation]).To(ContainSubstring(
+			`"spec.url":{"original":"oci://ghcr.io/stefanprodan/manifests/podinfo"`))
+	})
+
+	It("should allow sources unchanged when disabled", func() {
+		mutator.Disabled = true
+		source := newSource("source.toolkit.fluxcd.io/v1", "OCIRepository", map[string]any{
+			"url": "oci://ghcr.io/stefanprodan/manifests/podinfo",
+		})
+
+		resp := mutator.Handle(ctx, newRequest(source))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(resp.Patches).To(BeEmpty())
+	})
+
+	It("should only rewrite the URL of Flux HelmRepository resources of type oci", func() {
+		source := newSource("source.toolkit.fluxcd.io/v1", "HelmRepository", map[string]any{
+			"type": "oci",
+			"url":  "oci://ghcr.io/stefanprodan/charts/",
+		})
+		resp := mutator.Handle(ctx, newRequest(source))
+		Expect(patchValue(resp, "/spec/url")).To(Equal("oci://mirror.local/ghcr/stefanprodan/charts"))
+
+		source = newSource("source.toolkit.fluxcd.io/v1", "HelmRepository", map[string]any{
+			"url": "https://ghcr.io/stefanprodan/charts",
+		})
+		resp = mutator.Handle(ctx, newRequest(source))
+		Expect(resp.Patches).To(BeEmpty())
+	})
+
+	It("should not add the library namespace to Docker Hub repository prefixes", func() {
+		setup(devv1alpha1.Rule{Match: `^docker\.io/(.*)`, Replace: "mirror.local/dockerhub/$1"})
+		source := newSource("source.toolkit.fluxcd.io/v1", "HelmRepository", map[string]any{
+			"type": "oci",
+			"url":  "oci://docker.io/bitnamicharts",
+		})
+		resp := mutator.Handle(ctx, newRequest(source))
+		Expect(patchValue(resp, "/spec/url")).To(Equal("oci://mirror.local/dockerhub/bitnamicharts"))
+
+		setup(devv1alpha1.Rule{Match: `^docker\.io/(.*)`, Replace: "docker.io/$1"})
+		resp = mutator.Handle(ctx, newRequest(source))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(resp.Patches).To(BeEmpty())
+	})
+
+	It("should rewrite the OCI sources of Argo CD applications", func() {
+		app := newSource("argoproj.io/v1alpha1", "Application", map[string]any{
+			"sources": []any{
+				map[string]any{"repoURL": "oci://ghcr.io/example/manifests", "targetRevision": "1.0.0"},
+				map[string]any{"repoURL": "ghcr.io/example/charts", "chart": "app", "targetRevision": "2.0.0"},
+				map[string]any{"repoURL": "https://github.com/example/config.git", "path": "overlays"},
+			},
+		})
+
+		resp := mutator.Handle(ctx, newRequest(app))
+		Expect(patchValue(resp, "/spec/sources/0/repoURL")).To(Equal("oci://mirror.local/ghcr/example/manifests"))
+		Expect(patchValue(resp, "/spec/sources/1/repoURL")).To(Equal("mirror.local/ghcr/example/charts"))
+		Expect(patchValue(resp, "/spec/sources/2/repoURL")).To(BeNil())
+	})
+
+	It("should not apply rules restricted to other image sources", func() {
+		setup(devv1alpha1.Rule{
+			Match:   `^ghcr\.io/(.*)`,
+			Replace: "mirror.local/ghcr/$1",
+			Conditions: &devv1alpha1.RuleConditions{
+				ImageSources: []devv1alpha1.ImageSource{devv1alpha1.ImageSourceContainer},
+			},
+		})
+		app := newSource("argoproj.io/v1alpha1", "Application", map[string]any{
+			"source": map[string]any{"repoURL": "oci://ghcr.io/example/manifests"},
+		})
+
+		resp := mutator.Handle(ctx, newRequest(app))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(resp.Patches).To(BeEmpty())
+	})
+
+	It("should not apply image options to OCI artifact references", func() {
+		setup(devv1alpha1.Rule{
+			Match:            `^ghcr\.io/(.*)`,
+			Replace:          "mirror.local/ghcr/$1",
+			MutableTagPolicy: devv1alpha1.MutableTagPolicyRefuse,
+			Tags:             &devv1alpha1.TagRewrite{Mapping: map[string]string{"latest": "1.0.0"}},
+		})
+		source := newSource("source.toolkit.fluxcd.io/v1", "OCIRepository", map[string]any{
+			"url": "oci://ghcr.io/stefanprodan/manifests/podinfo",
+		})
+
+		resp := mutator.Handle(ctx, newRequest(source))
+		Expect(patchValue(resp, "/spec/url")).To(Equal("oci://mirror.local/ghcr/stefanprodan/manifests/podinfo"))
+	})
+})
@@ -0,0 +1,188 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+// +kubebuilder:rbac:groups="",resources=configmaps;secrets,verbs=get;list;watch
+
+// errMissingParameter is returned when the ConfigMap or Secret key holding
+// the value of a parameter doesn't exist
+var errMissingParameter = errors.New("parameter value not found")
+
+// parameterValues holds the resolved parameters of RegistryRewriteRule
+// resources, keyed by resource name
+type parameterValues map[string]map[string]string
+
+// resolveAllParameters resolves the parameters of the given resources. The
+// parameters that can't be resolved are left out, so that the rules using
+// them fail to render and are skipped.
+func resolveAllParameters(
+	ctx context.Context, reader client.Reader, items []devv1alpha1.RegistryRewriteRule,
+) parameterValues {
+	values := parameterValues{}
+	for i := range items {
+		values[items[i].Name], _ = resolveParameters(ctx, reader, &items[i])
+	}
+	return values
+}
+
+// resolveParameters returns the values of the parameters of a resource. The
+// error lists the parameters that can't be resolved, which are left out.
+func resolveParameters(
+	ctx context.Context, reader client.Reader, rr *devv1alpha1.RegistryRewriteRule,
+) (map[string]string, error) {
+	values := map[string]string{}
+	var errs []error
+	for _, param := range rr.Spec.Parameters {
+		value, err := resolveParameter(ctx, reader, param)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parameter %q: %w", param.Name, err))
+			continue
+		}
+		values[param.Name] = value
+	}
+	return values, errors.Join(errs...)
+}
+
+// resolveParameter returns the value of a parameter
+func resolveParameter(ctx context.Context, reader client.Reader, param devv1alpha1.Parameter) (string, error) {
+	source := param.ValueFrom
+	switch {
+	case source == nil:
+		return param.Value, nil
+	case source.ConfigMapKeyRef != nil:
+		ref := source.ConfigMapKeyRef
+		configMap := &corev1.ConfigMap{}
+		if err := getParameterSource(ctx, reader, ref, configMap); err != nil {
+			return "", err
+		}
+		if value, ok := configMap.Data[ref.Key]; ok {
+			return value, nil
+		}
+		if value, ok := configMap.BinaryData[ref.Key]; ok {
+			return string(value), nil
+		}
+		return "", fmt.Errorf("%w: key %q of ConfigMap %s/%s", errMissingParameter, ref.Key, ref.Namespace, ref.Name)
+	case source.SecretKeyRef != nil:
+		ref := source.SecretKeyRef
+		secret := &corev1.Secret{}
+		if err := getParameterSource(ctx, reader, ref, secret); err != nil {
+			return "", err
+		}
+		if value, ok := secret.Data[ref.Key]; ok {
+			return string(value), nil
+		}
+		return "", fmt.Errorf("%w: key %q of Secret %s/%s", errMissingParameter, ref.Key, ref.Namespace, ref.Name)
+	default:
+		return "", errors.New("valueFrom has no source")
+	}
+}
+
+// getParameterSource gets the ConfigMap or Secret holding the value of a
+// parameter
+func getParameterSource(ctx context.Context, reader client.Reader, ref *devv1alpha1.KeyReference, obj client.Object) error {
+	key := types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}
+	if err := reader.Get(ctx, key, obj); err != nil {
+		kind := "Secret"
+		if _, ok := obj.(*corev1.ConfigMap); ok {
+			kind = "ConfigMap"
+		}
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("%w: %s %s not found", errMissingParameter, kind, key)
+		}
+		return fmt.Errorf("failed to get %s %s: %w", kind, key, err)
+	}
+	return nil
+}
+
+// renderReplace executes the replace template of a rule with the values of
+// the parameters of its resource. Dollar signs of the values are escaped so
+// that they aren't expanded as match groups.
+func renderReplace(replace string, values map[string]string) (string, error) {
+	if !strings.Contains(replace, "{{") {
+		return replace, nil
+	}
+
+	tmpl, err := template.New("replace").Option("missingkey=error").Parse(replace)
+	if err != nil {
+		return "", fmt.Errorf("invalid replace template: %w", err)
+	}
+	escaped := make(map[string]string, len(values))
+	for name, value := range values {
+		escaped[name] = strings.ReplaceAll(value, "$", "$$")
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, escaped); err != nil {
+		return "", fmt.Errorf("failed to render replace template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// parametersHash returns a hash of the resolved parameters of a resource, so
+// that the rule set hash changes with their values
+func parametersHash(values map[string]string) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%s\x00", name, values[name])
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// referencesObject reports whether a parameter of a resource reads its value
+// from the given ConfigMap or Secret, or whether one of its rules maps tags
+// with the given ConfigMap
+func referencesObject(rr *devv1alpha1.RegistryRewriteRule, kind string, key types.NamespacedName) bool {
+	if kind == "ConfigMap" && referencesTagMapping(rr, key) {
+		return true
+	}
+	for _, param := range rr.Spec.Parameters {
+		if param.ValueFrom == nil {
+			continue
+		}
+		ref := param.ValueFrom.SecretKeyRef
+		if kind == "ConfigMap" {
+			ref = param.ValueFrom.ConfigMapKeyRef
+		}
+		if ref != nil && ref.Name == key.Name && ref.Namespace == key.Namespace {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var _ = Describe("Rule parameters", func() {
+	var (
+		ctx     context.Context
+		c       client.Client
+		mutator *PodMutator
+		watcher *RulesWatcher
+		pod     *corev1.Pod
+	)
+
+	clusterConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-config", Namespace: "registry-system"},
+		Data:       map[string]string{"region": "eu-west-1"},
+	}
+	awsAccount := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "aws-account", Namespace: "registry-system"},
+		Data:       map[string][]byte{"id": []byte("123456789012")},
+	}
+
+	setup := func(objs ...client.Object) {
+		objs = append(objs, &devv1alpha1.RegistryRewriteRule{
+			ObjectMeta: metav1.ObjectMeta{Name: "ecr"},
+			Spec: devv1alpha1.RegistryRewriteRuleSpec{
+				Parameters: []devv1alpha1.Parameter{
+					{Name: "accountId", ValueFrom: &devv1alpha1.ParameterSource{
+						SecretKeyRef: &devv1alpha1.KeyReference{Name: "aws-account", Namespace: "registry-system", Key: "id"},
+					}},
+					{Name: "region", ValueFrom: &devv1alpha1.ParameterSource{
+						ConfigMapKeyRef: &devv1alpha1.KeyReference{Name: "cluster-config", Namespace: "registry-system", Key: "region"},
+					}},
+					{Name: "cache", Value: "docker-hub"},
+				},
+				Rules: []devv1alpha1.Rule{{
+					Match:   `^docker\.io/(.*)`,
+					Replace: "{{ .accountId }}.dkr.ecr.{{ .region }}.amazonaws.com/{{ .cache }}/$1",
+				}},
+			},
+		}, newRuleResource("static", devv1alpha1.Rule{Match: `^quay\.io/(.*)`, Replace: "mirror.local/quay/$1"}))
+		mutator = newTestMutator(objs...)
+		c = mutator.Client
+		watcher = &RulesWatcher{Client: c, Mutator: mutator}
+	}
+
+	readyCondition := func() *metav1.Condition {
+		_, err := watcher.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: "ecr"}})
+		Expect(err).NotTo(HaveOccurred())
+		rr := &devv1alpha1.RegistryRewriteRule{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "ecr"}, rr)).To(Succeed())
+		condition := meta.FindStatusCondition(rr.Status.Conditions, devv1alpha1.ConditionReady)
+		Expect(condition).NotTo(BeNil())
+		Expect(rr.Status.Ready).To(Equal(condition.Status == metav1.ConditionTrue))
+		return condition
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Image: "nginx:1.25"}},
+			},
+		}
+	})
+
+	It("should render replace templates with the parameter values", func() {
+		setup(clusterConfig.DeepCopy(), awsAccount.DeepCopy())
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(
+			Equal("123456789012.dkr.ecr.eu-west-1.amazonaws.com/docker-hub/library/nginx:1.25"))
+		Expect(readyCondition().Reason).To(Equal("Valid"))
+	})
+
+	It("should report a missing parameter and resolve it once created", func() {
+		setup(awsAccount.DeepCopy())
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(BeNil())
+		condition := readyCondition()
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal("ParameterMissing"))
+		Expect(condition.Message).To(ContainSubstring("registry-system/cluster-config not found"))
+
+		pod.Spec.Containers[0].Image = "quay.io/prometheus/node-exporter:v1.7.0"
+		resp = mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/quay/prometheus/node-exporter:v1.7.0"))
+
+		Expect(c.Create(ctx, clusterConfig.DeepCopy())).To(Succeed())
+		requests := watcher.enqueueRulesReferencing("ConfigMap")(ctx, clusterConfig)
+		Expect(requests).To(ConsistOf(reconcile.Request{NamespacedName: types.NamespacedName{Name: "ecr"}}))
+		Expect(readyCondition().Status).To(Equal(metav1.ConditionTrue))
+
+		pod.Spec.Containers[0].Image = "nginx:1.25"
+		resp = mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(
+			Equal("123456789012.dkr.ecr.eu-west-1.amazonaws.com/docker-hub/library/nginx:1.25"))
+	})
+
+	It("should change the rule set hash with the parameter values", func() {
+		setup(clusterConfig.DeepCopy(), awsAccount.DeepCopy())
+		ruleSet, err := mutator.getRules(ctx)
+		Expect(err).NotTo(HaveOccurred())
+
+		updated := clusterConfig.DeepCopy()
+		updated.Data["region"] = "us-east-1"
+		Expect(c.Update(ctx, updated)).To(Succeed())
+		readyCondition()
+		updatedSet, err := mutator.getRules(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updatedSet.hash).NotTo(Equal(ruleSet.hash))
+		Expect(updatedSet.rules[0].replace).To(Equal("123456789012.dkr.ecr.us-east-1.amazonaws.com/docker-hub/$1"))
+	})
+
+	It("should not enqueue rules for unrelated objects", func() {
+		setup(clusterConfig.DeepCopy(), awsAccount.DeepCopy())
+
+		Expect(watcher.enqueueRulesReferencing("Secret")(ctx, clusterConfig)).To(BeEmpty())
+		Expect(watcher.enqueueRulesReferencing("Secret")(ctx, awsAccount)).To(HaveLen(1))
+	})
+
+	It("should escape dollar signs of the values", func() {
+		replace, err := renderReplace("{{ .host }}/$1", map[string]string{"host": "mirror$1.local"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(replace).To(Equal("mirror$$1.local/$1"))
+
+		_, err = renderReplace("{{ .host }}/$1", nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -18,27 +18,135 @@ package webhook
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/events"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+	"github.com/flemzord/mutating-registry-webhook/internal/breaker"
+	"github.com/flemzord/mutating-registry-webhook/internal/presets"
+	"github.com/flemzord/mutating-registry-webhook/internal/registry"
+)
+
+// Annotations of the pods handled by the webhook
+const (
+	// RewriteDisabledAnnotation disables the rewrite of the images of a pod
+	// when set to "true"
+	RewriteDisabledAnnotation = "rewrite-disabled"
+	// AuditRewritesAnnotation records, as a JSON map of container name to
+	// image, the rewrites computed for a pod by rules in audit mode
+	AuditRewritesAnnotation = "dev.flemzord.fr/audit-rewrites"
+	// OriginalImagesAnnotation records, as a JSON map of container name to
+	// ImageRecord, the images rewritten by the webhook
+	OriginalImagesAnnotation = "dev.flemzord.fr/original-images"
+	// RuleSetHashAnnotation records the hash of the rule set generation that
+	// processed the pod
+	RuleSetHashAnnotation = "dev.flemzord.fr/rule-set-hash"
+	// PinnedTagsAnnotation records, as a JSON map of container name to
+	// image, the tagged images whose digest was pinned
+	PinnedTagsAnnotation = "dev.flemzord.fr/pinned-tags"
+	// OriginalEnvImagesAnnotation records, as a JSON map of container and
+	// variable names joined by a slash to ImageRecord, the images of
+	// environment variables rewritten by the webhook
+	OriginalEnvImagesAnnotation = "dev.flemzord.fr/original-env-images"
+	// OriginalVolumeImagesAnnotation records, as a JSON map of volume name to
+	// ImageRecord, the references of image volumes rewritten by the webhook
+	OriginalVolumeImagesAnnotation = "dev.flemzord.fr/original-volume-images"
+)
+
+// DefaultAdmissionTimeout is the default bound of the handling of a pod,
+// below the default timeoutSeconds of 10s of admission webhooks
+const DefaultAdmissionTimeout = 8 * time.Second
+
+// Labels of the pods handled by the webhook
+const (
+	// RewrittenLabel is set to "true" on the pods whose container images
+	// were rewritten, so that controllers only cache those pods
+	RewrittenLabel = "dev.flemzord.fr/rewritten"
+)
+
+// Mutation statuses reported by the registry_rewriter_mutations_total metric
+const (
+	statusSuccess = "success"
+	statusAudit   = "audit"
+	// statusInvalidResult is reported when a rule produces an invalid image
+	statusInvalidResult = "invalid_result"
+	// statusError is reported when a matched image can't be rewritten
+	statusError = "error"
+	// statusDigestMismatch is reported when a rule changes or removes the
+	// digest of an image pinned by digest
+	statusDigestMismatch = "digest_mismatch"
+	// statusImageNotFound is reported when a verified rewritten image does
+	// not exist in the target registry
+	statusImageNotFound = "image_not_found"
+	// statusSuspended is reported when a matching rule is skipped because
+	// its circuit breaker is open
+	statusSuspended = "suspended"
+	// statusMissingPullSecret is reported when an image pull secret required
+	// by a rule doesn't exist in the namespace of the pod
+	statusMissingPullSecret = "missing_pull_secret"
+	// statusMutableTag is reported when a rule refuses to rewrite an image
+	// to a mutable tag
+	statusMutableTag = "mutable_tag"
 )
 
 // PodMutator mutates Pods
 type PodMutator struct {
-	Client          client.Client
+	Client client.Client
+	// Mode, when set, overrides the mode of every rule
+	Mode devv1alpha1.RewriteMode
+	// AdmissionWarnings enables admission warnings describing rewrites
+	AdmissionWarnings bool
+	// AuditAnnotations enables audit annotations describing rewrites
+	AuditAnnotations bool
+	// QuietNamespaces lists namespaces for which admission warnings and
+	// audit annotations are not emitted
+	QuietNamespaces []string
+	// Recorder, when set, records events describing rewrites
+	Recorder events.EventRecorder
+	// EventLimiter limits the rate of recorded events
+	EventLimiter *EventLimiter
+	// PodEvents emits the events of pods without owner once they are
+	// created. Their events are dropped when nil.
+	PodEvents *PodEventQueue
+	// APIReader looks up pods created after admission and registry
+	// credentials. Client is used when nil.
+	APIReader client.Reader
+	// Registry looks up images in registries, for rules verifying that the
+	// rewritten image exists or pinning its digest
+	Registry *registry.Client
+	// AdmissionTimeout bounds the handling of a pod, including the registry
+	// lookups of all its images. DefaultAdmissionTimeout is used when zero.
+	AdmissionTimeout time.Duration
+	// PinDigests pins the digest of the images rewritten by every rule
+	PinDigests bool
+	// Mirrors tracks the health of the targets of the rules. All targets
+	// are considered healthy when nil.
+	Mirrors *MirrorProber
+	// Breaker suspends rules whose rewritten images fail to pull. No rule is
+	// suspended when nil.
+	Breaker *breaker.Breaker
+	// MirrorStats, when set, counts the pods served by each RegistryMirror
+	MirrorStats *RegistryMirrorStats
+
 	decoder         admission.Decoder
 	rulesCache      *rulesCache
 	rulesCacheMutex sync.RWMutex
@@ -49,11 +157,46 @@ type compiledRule struct {
 	rule    devv1alpha1.Rule
 	regex   *regexp.Regexp
 	replace string
+	// ruleName is the name of the RegistryRewriteRule, or RegistryMirror, the
+	// rule comes from
+	ruleName string
+	// index is the position of the rule in the RegistryRewriteRule spec, or
+	// of the source in the RegistryMirror spec
+	index int
+	// mirror is set when the rule comes from a RegistryMirror
+	mirror bool
+	// envNames select the environment variables rewritten by the rule, if any
+	envNames []*regexp.Regexp
+	// mode is the mode of the rule, resolved from the rule and its resource
+	mode devv1alpha1.RewriteMode
+	// tagMapping is the ConfigMap mapping the tags of the rule, read when
+	// the rules are compiled
+	tagMapping tagMapping
+}
+
+// imageRewrite describes the rewrite of a single image by a rule
+type imageRewrite struct {
+	container string
+	original  string
+	rewritten string
+	rule      compiledRule
+	mode      devv1alpha1.RewriteMode
+	// pinnedFrom is the tagged rewritten image, when its digest was pinned
+	pinnedFrom string
+	// warning is set when the mutable tag policy of the rule warns about
+	// the rewritten image
+	warning string
+	// err is set when the rule produced an invalid image, which is then
+	// not applied
+	err error
 }
 
 // rulesCache holds compiled rules
 type rulesCache struct {
 	rules []compiledRule
+	// hash identifies the generation of the RegistryRewriteRule resources
+	// the rules were compiled from
+	hash string
 }
 
 // Prometheus metrics
@@ -83,14 +226,19 @@ var (
 		Name: "registry_rewriter_cache_misses_total",
 		Help: "Total number of cache misses",
 	})
+
+	digestPinsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "registry_rewriter_digest_pins_total",
+		Help: "Total number of rewritten images whose tag was resolved to a digest",
+	}, []string{"status"})
 )
 
 func init() {
 	// Register metrics with controller-runtime metrics registry
-	metrics.Registry.MustRegister(mutationsTotal, mutationDuration, rulesCount, cacheHits, cacheMisses)
+	metrics.Registry.MustRegister(mutationsTotal, mutationDuration, rulesCount, cacheHits, cacheMisses, digestPinsTotal)
 }
 
-// +kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=ignore,groups="",resources=pods,verbs=create;update,versions=v1,name=mpod.dev.flemzord.fr,admissionReviewVersions=v1;v1beta1,sideEffects=None
+// +kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=ignore,groups="",resources=pods,verbs=create;update,versions=v1,name=mpod.dev.flemzord.fr,admissionReviewVersions=v1;v1beta1,sideEffects=NoneOnDryRun
 
 // Handle handles Pod admission requests
 func (m *PodMutator) Handle(ctx context.Context, req admission.Request) admission.Response {
@@ -98,6 +246,14 @@ func (m *PodMutator) Handle(ctx context.Context, req admission.Request) admissio
 	pod := &corev1.Pod{}
 	logger := log.FromContext(ctx)
 
+	// Bound the sequential registry lookups with the admission deadline
+	timeout := m.AdmissionTimeout
+	if timeout == 0 {
+		timeout = DefaultAdmissionTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
 	// Record mutation duration
 	defer func() {
 		mutationDuration.WithLabelValues(req.Namespace).Observe(time.Since(start).Seconds())
@@ -110,18 +266,19 @@ func (m *PodMutator) Handle(ctx context.Context, req admission.Request) admissio
 	}
 
 	// Check if mutation is disabled via annotation
-	if pod.Annotations != nil && pod.Annotations["rewrite-disabled"] == "true" {
+	if pod.Annotations != nil && pod.Annotations[RewriteDisabledAnnotation] == "true" {
 		logger.Info("Skipping mutation, rewrite-disabled annotation found", "pod", pod.Name, "namespace", pod.Namespace)
 		return admission.Allowed("rewrite disabled")
 	}
 
 	// Get current rules
-	rules, err := m.getRules(ctx)
+	ruleSet, err := m.getRules(ctx)
 	if err != nil {
 		logger.Error(err, "Failed to get rules")
 		// Don't fail the admission if we can't get rules
 		return admission.Allowed("failed to get rules")
 	}
+	rules := ruleSet.rules
 
 	if len(rules) == 0 {
 		return admission.Allowed("no rules configured")
@@ -129,39 +286,121 @@ func (m *PodMutator) Handle(ctx context.Context, req admission.Request) admissio
 
 	// Apply mutations
 	mutated := false
+	audited := map[string]string{}
+	var rewrites, failed []imageRewrite
+	var warnings []string
+
+	dryRun := req.DryRun != nil && *req.DryRun
+	// Only the images of existing pods can be changed on update
+	update := req.Operation == admissionv1.Update
+	forEachContainerImage(pod, func(source devv1alpha1.ImageSource, name string, image *string) {
+		kind := imageSourceKinds[source]
+		rewrite, ok := m.rewriteSourceImage(ctx, source, *image, rules, pod, !dryRun && pullTracked[source])
+		if !ok {
+			return
+		}
+		rewrite.container = name
+		if rewrite.err != nil {
+			failed = append(failed, rewrite)
+			logger.Error(rewrite.err, "Rule produced an invalid "+kind+" image, keeping the original",
+				"container", name, "image", *image, "rule", ruleRef(rewrite.rule))
+			return
+		}
+		if rewrite.warning != "" {
+			warnings = append(warnings, rewrite.warning)
+			logger.Info("Rewritten "+kind+" image uses a mutable tag", "container", name, "image", rewrite.rewritten,
+				"rule", ruleRef(rewrite.rule))
+		}
+		if rewrite.rewritten == normalizeImage(*image) {
+			// The rule keeps the image as it is
+			return
+		}
+		if missing := missingImagePullSecrets(pod, rewrite); update && len(missing) > 0 {
+			logger.Info("Skipping "+kind+" image rewrite, its image pull secrets can't be added on update",
+				"container", name, "image", *image, "secrets", missing, "rule", ruleRef(rewrite.rule))
+			return
+		}
+		rewrites = append(rewrites, rewrite)
+		if rewrite.mode == devv1alpha1.RewriteModeAudit {
+			audited[name] = rewrite.rewritten
+			logger.Info("Audited "+kind+" image rewrite", "container", name, "from", *image, "to", rewrite.rewritten,
+				"rule", ruleRef(rewrite.rule))
+			return
+		}
+		*image = rewrite.rewritten
+		mutated = true
+		logger.Info("Mutated "+kind+" image", "container", name, "from", rewrite.original, "to", rewrite.rewritten)
+	})
+
+	m.emitEvents(ctx, req, pod, rewrites, failed)
+
+	if mutated {
+		if err := annotateRewrites(pod, rewrites, ruleSet.hash); err != nil {
+			logger.Error(err, "Failed to annotate pod with rewrites")
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		if !update {
+			for _, o := range setPullPolicies(pod, rewrites) {
+				logger.Info("Set image pull policy", "container", o.container, "from", o.from, "to", o.to,
+					"rule", ruleRef(o.rule))
+			}
+		}
+	}
+
+	// Volumes can't be changed on existing pods
+	var volumeRewrites []imageRewrite
+	if !update {
+		volumeRewrites = m.rewriteImageVolumes(ctx, pod, rules)
+	}
+	if len(volumeRewrites) > 0 {
+		if err := annotateImageRecords(pod, OriginalVolumeImagesAnnotation, volumeRewrites); err != nil {
+			logger.Error(err, "Failed to annotate pod with image volume rewrites")
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		mutated = true
+	}
 
-	// Mutate containers
-	for i, container := range pod.Spec.Containers {
-		newImage := m.mutateImage(ctx, container.Image, rules, pod)
-		if newImage != container.Image {
-			pod.Spec.Containers[i].Image = newImage
-			mutated = true
-			logger.Info("Mutated container image", "container", container.Name, "from", container.Image, "to", newImage)
+	if mutated {
+		// The kubelet pulls the images of containers and image volumes
+		pulled := slices.Concat(rewrites, volumeRewrites)
+		if !update {
+			if added := addImagePullSecrets(pod, pulled); len(added) > 0 {
+				logger.Info("Added image pull secrets", "secrets", added)
+			}
+		}
+		if !dryRun {
+			m.MirrorStats.recordServed(pulled)
 		}
 	}
 
-	// Mutate init containers
-	for i, container := range pod.Spec.InitContainers {
-		newImage := m.mutateImage(ctx, container.Image, rules, pod)
-		if newImage != container.Image {
-			pod.Spec.InitContainers[i].Image = newImage
-			mutated = true
-			logger.Info("Mutated init container image", "container", container.Name, "from", container.Image, "to", newImage)
+	// Environment variables can't be changed on existing pods
+	var envRewrites []imageRewrite
+	if !update {
+		envRewrites = m.rewriteEnvVars(ctx, pod, rules)
+	}
+	if len(envRewrites) > 0 {
+		if err := annotateImageRecords(pod, OriginalEnvImagesAnnotation, envRewrites); err != nil {
+			logger.Error(err, "Failed to annotate pod with environment variable rewrites")
+			return admission.Errored(http.StatusInternalServerError, err)
 		}
+		mutated = true
 	}
 
-	// Mutate ephemeral containers
-	for i, container := range pod.Spec.EphemeralContainers {
-		newImage := m.mutateImage(ctx, container.Image, rules, pod)
-		if newImage != container.Image {
-			pod.Spec.EphemeralContainers[i].Image = newImage
-			mutated = true
-			logger.Info("Mutated ephemeral container image", "container", container.Name, "from", container.Image, "to", newImage)
+	if len(audited) > 0 {
+		value, err := json.Marshal(audited)
+		if err != nil {
+			logger.Error(err, "Failed to marshal audited rewrites")
+			return admission.Errored(http.StatusInternalServerError, err)
 		}
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[AuditRewritesAnnotation] = string(value)
+		mutated = true
 	}
 
 	if !mutated {
-		return admission.Allowed("no mutations needed")
+		return admission.Allowed("no mutations needed").WithWarnings(warnings...)
 	}
 
 	// Create the patch
@@ -171,37 +410,244 @@ func (m *PodMutator) Handle(ctx context.Context, req admission.Request) admissio
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
 
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+	resp := admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod).WithWarnings(warnings...)
+	return m.describeRewrites(resp, pod, rewrites)
+}
+
+// imageSourceKinds are the names of the image sources used in logs
+var imageSourceKinds = map[devv1alpha1.ImageSource]string{
+	devv1alpha1.ImageSourceContainer:          "container",
+	devv1alpha1.ImageSourceInitContainer:      "init container",
+	devv1alpha1.ImageSourceEphemeralContainer: "ephemeral container",
+	devv1alpha1.ImageSourceImageVolume:        "image volume",
+	devv1alpha1.ImageSourceOCIArtifact:        "OCI artifact",
+}
+
+// pullTracked are the image sources whose pull outcome is recorded in the
+// circuit breakers of the rules
+var pullTracked = map[devv1alpha1.ImageSource]bool{
+	devv1alpha1.ImageSourceContainer:     true,
+	devv1alpha1.ImageSourceInitContainer: true,
+}
+
+// forEachContainerImage calls fn with the source, the name and a pointer to
+// the image of every container, init container and ephemeral container of the
+// pod
+func forEachContainerImage(pod *corev1.Pod, fn func(source devv1alpha1.ImageSource, name string, image *string)) {
+	for i := range pod.Spec.Containers {
+		fn(devv1alpha1.ImageSourceContainer, pod.Spec.Containers[i].Name, &pod.Spec.Containers[i].Image)
+	}
+	for i := range pod.Spec.InitContainers {
+		fn(devv1alpha1.ImageSourceInitContainer, pod.Spec.InitContainers[i].Name, &pod.Spec.InitContainers[i].Image)
+	}
+	for i := range pod.Spec.EphemeralContainers {
+		fn(devv1alpha1.ImageSourceEphemeralContainer, pod.Spec.EphemeralContainers[i].Name,
+			&pod.Spec.EphemeralContainers[i].Image)
+	}
 }
 
 // mutateImage applies rules to an image and returns the mutated image
 func (m *PodMutator) mutateImage(ctx context.Context, image string, rules []compiledRule, pod *corev1.Pod) string {
-	logger := log.FromContext(ctx)
+	rewrite, ok := m.rewriteImage(ctx, image, rules, pod)
+	if ok && rewrite.err == nil && rewrite.mode != devv1alpha1.RewriteModeAudit {
+		return rewrite.rewritten
+	}
+	return image
+}
 
+// rewriteImage applies rules to the image of a container and returns the
+// rewrite of the first matching rule, if any
+func (m *PodMutator) rewriteImage(
+	ctx context.Context, image string, rules []compiledRule, pod *corev1.Pod,
+) (imageRewrite, bool) {
+	return m.rewriteSourceImage(ctx, devv1alpha1.ImageSourceContainer, image, rules, pod, true)
+}
+
+// rewriteSourceImage applies rules to an image of the given source and
+// returns the rewrite of the first matching rule, if any. Rules whose circuit
+// breaker isn't closed are skipped, unless trial is set and the enforced
+// rewrite may be the trial of a half-open circuit: the outcome of its pull
+// must then be recorded in the breaker.
+func (m *PodMutator) rewriteSourceImage(
+	ctx context.Context, source devv1alpha1.ImageSource, image string, rules []compiledRule, pod *corev1.Pod,
+	trial bool,
+) (imageRewrite, bool) {
 	// Normalize image name (add docker.io prefix if needed)
 	normalizedImage := normalizeImage(image)
+	if source == devv1alpha1.ImageSourceOCIArtifact {
+		normalizedImage = normalizeOCISource(image)
+	}
 
 	for _, rule := range rules {
 		// Check conditions
-		if !m.checkConditions(rule.rule, pod) {
+		if !m.checkConditions(rule.rule, pod) || !checkImageSource(rule.rule, source) {
 			continue
 		}
 
 		// Apply regex
 		if rule.regex.MatchString(normalizedImage) {
-			newImage := rule.regex.ReplaceAllString(normalizedImage, rule.replace)
-			logger.V(1).Info("Image matched rule", "image", normalizedImage, "match", rule.rule.Match, "newImage", newImage)
+			// Skip rules suspended by their circuit breaker
+			allowed := m.Breaker.State(ruleRef(rule)) == breaker.StateClosed
+			if trial && m.effectiveMode(rule) != devv1alpha1.RewriteModeAudit {
+				allowed = m.Breaker.Allow(ruleRef(rule))
+			}
+			if !allowed {
+				mutationsTotal.WithLabelValues(pod.Namespace, extractRegistry(normalizedImage), "", statusSuspended).Inc()
+				log.FromContext(ctx).Info("Skipping rule suspended by its circuit breaker", "image", image,
+					"rule", ruleRef(rule))
+				continue
+			}
+			return m.applyRule(ctx, source, image, normalizedImage, rule, pod), true
+		}
+	}
 
-			// Extract registries for metrics
-			sourceReg := extractRegistry(normalizedImage)
-			targetReg := extractRegistry(newImage)
-			mutationsTotal.WithLabelValues(pod.Namespace, sourceReg, targetReg, "success").Inc()
+	return imageRewrite{}, false
+}
 
-			return newImage
+// applyRule rewrites a normalized image matched by rule. The returned
+// rewrite carries an error when the result can't be applied. OCI artifact
+// references, which have no tag, are only rewritten to their target.
+func (m *PodMutator) applyRule(
+	ctx context.Context, source devv1alpha1.ImageSource, image, normalizedImage string, rule compiledRule,
+	pod *corev1.Pod,
+) imageRewrite {
+	logger := log.FromContext(ctx)
+	artifact := source == devv1alpha1.ImageSourceOCIArtifact
+
+	newImage := rule.regex.ReplaceAllString(normalizedImage, rule.replace)
+	logger.V(1).Info("Image matched rule", "image", normalizedImage, "match", rule.rule.Match, "newImage", newImage)
+
+	// Extract registries for metrics
+	sourceReg := extractRegistry(normalizedImage)
+
+	// Validate the images before applying the rewrite, falling back to
+	// the original image on failure
+	originalRef, err := parseReference(normalizedImage)
+	if err != nil {
+		mutationsTotal.WithLabelValues(pod.Namespace, sourceReg, "", statusError).Inc()
+		return imageRewrite{original: image, rule: rule, err: fmt.Errorf("invalid image %q: %w", image, err)}
+	}
+	newRef, err := parseReference(newImage)
+	if err != nil {
+		mutationsTotal.WithLabelValues(pod.Namespace, sourceReg, "", statusInvalidResult).Inc()
+		return imageRewrite{original: image, rule: rule, err: fmt.Errorf("invalid rewritten image %q: %w", newImage, err)}
+	}
+
+	// Rewrite to the selected target
+	if len(rule.rule.Targets) > 0 {
+		newRef.domain = m.selectTarget(rule, pod)
+		newImage = newRef.String()
+	}
+
+	// Rewrite the tag, validating the result again
+	if rule.rule.Tags != nil && !artifact {
+		tagged, err := rewriteTag(newRef, rule)
+		if err != nil {
+			mutationsTotal.WithLabelValues(pod.Namespace, sourceReg, "", statusError).Inc()
+			return imageRewrite{original: image, rule: rule, err: fmt.Errorf("failed to rewrite the tag of %q: %w", newImage, err)}
+		}
+		newImage = tagged.String()
+		if newRef, err = parseReference(newImage); err != nil {
+			mutationsTotal.WithLabelValues(pod.Namespace, sourceReg, "", statusInvalidResult).Inc()
+			return imageRewrite{original: image, rule: rule, err: fmt.Errorf("invalid rewritten image %q: %w", newImage, err)}
 		}
 	}
 
-	return image
+	// Refuse rewrites breaking the digest pinning, unless allowed
+	targetReg := extractRegistry(newImage)
+	if originalRef.digest != "" && newRef.digest != originalRef.digest && !rule.rule.AllowDigestChange {
+		mutationsTotal.WithLabelValues(pod.Namespace, sourceReg, targetReg, statusDigestMismatch).Inc()
+		return imageRewrite{
+			original: image,
+			rule:     rule,
+			err:      fmt.Errorf("rewritten image %q does not preserve digest %s", newImage, originalRef.digest),
+		}
+	}
+
+	// Check that the target registry has the image and pin its digest
+	pinDigest := (m.PinDigests || rule.rule.PinDigest) && newRef.digest == ""
+	pinnedFrom := ""
+	if (rule.rule.Verify || pinDigest) && m.Registry != nil && !artifact {
+		manifest, err := m.lookupManifest(ctx, newRef, rule)
+		if err != nil && rule.rule.Verify {
+			status := statusError
+			if errors.Is(err, registry.ErrManifestNotFound) {
+				status = statusImageNotFound
+			}
+			mutationsTotal.WithLabelValues(pod.Namespace, sourceReg, targetReg, status).Inc()
+			return imageRewrite{
+				original: image,
+				rule:     rule,
+				err:      fmt.Errorf("failed to verify rewritten image %q: %w", newImage, err),
+			}
+		}
+
+		switch {
+		case !pinDigest:
+		case err != nil || manifest.Digest == "":
+			// Fall back to the plain rewrite
+			digestPinsTotal.WithLabelValues(statusError).Inc()
+			logger.Info("Failed to resolve image digest, keeping the tag", "image", newImage, "rule", ruleRef(rule),
+				"error", fmt.Sprint(err))
+		default:
+			digestPinsTotal.WithLabelValues(statusSuccess).Inc()
+			pinnedFrom = newImage
+			newRef.tag, newRef.digest = "", manifest.Digest
+			newImage = newRef.String()
+		}
+	}
+
+	// Check that the pod will be able to pull from the target registry
+	if rule.rule.RequireImagePullSecrets && !artifact {
+		if err := m.checkImagePullSecrets(ctx, rule, pod.Namespace); err != nil {
+			status := statusError
+			if errors.Is(err, errMissingPullSecret) {
+				status = statusMissingPullSecret
+			}
+			mutationsTotal.WithLabelValues(pod.Namespace, sourceReg, targetReg, status).Inc()
+			return imageRewrite{original: image, rule: rule, err: fmt.Errorf("cannot rewrite to %q: %w", newImage, err)}
+		}
+	}
+
+	// Apply the mutable tag policy to the image the pod will pull
+	warning := ""
+	if usesMutableTag(newRef) && !artifact {
+		switch mutableTagPolicy(rule) {
+		case devv1alpha1.MutableTagPolicyRefuse:
+			mutationsTotal.WithLabelValues(pod.Namespace, sourceReg, targetReg, statusMutableTag).Inc()
+			return imageRewrite{original: image, rule: rule, err: fmt.Errorf("rewritten image %q uses a mutable tag", newImage)}
+		case devv1alpha1.MutableTagPolicyWarn:
+			warning = fmt.Sprintf("image %s uses a mutable tag, flagged by rule %s", newImage, ruleRef(rule))
+		}
+	}
+
+	mode := m.effectiveMode(rule)
+	status := statusSuccess
+	if mode == devv1alpha1.RewriteModeAudit {
+		status = statusAudit
+	}
+
+	mutationsTotal.WithLabelValues(pod.Namespace, sourceReg, targetReg, status).Inc()
+
+	return imageRewrite{
+		original:   image,
+		rewritten:  newImage,
+		rule:       rule,
+		mode:       mode,
+		pinnedFrom: pinnedFrom,
+		warning:    warning,
+	}
+}
+
+// effectiveMode returns the mode of a rule, taking the global override into account
+func (m *PodMutator) effectiveMode(rule compiledRule) devv1alpha1.RewriteMode {
+	if m.Mode != "" {
+		return m.Mode
+	}
+	if rule.mode != "" {
+		return rule.mode
+	}
+	return devv1alpha1.RewriteModeEnforce
 }
 
 // checkConditions checks if a rule's conditions match the pod
@@ -236,87 +682,186 @@ func (m *PodMutator) checkConditions(rule devv1alpha1.Rule, pod *corev1.Pod) boo
 	return true
 }
 
+// checkImageSource checks if a rule's conditions allow the source of an image
+func checkImageSource(rule devv1alpha1.Rule, source devv1alpha1.ImageSource) bool {
+	if rule.Conditions == nil || len(rule.Conditions.ImageSources) == 0 {
+		return true
+	}
+	return slices.Contains(rule.Conditions.ImageSources, source)
+}
+
 // getRules fetches and compiles all rules
-func (m *PodMutator) getRules(ctx context.Context) ([]compiledRule, error) {
+func (m *PodMutator) getRules(ctx context.Context) (*rulesCache, error) {
 	m.rulesCacheMutex.RLock()
 	if m.rulesCache != nil && len(m.rulesCache.rules) > 0 {
-		rules := m.rulesCache.rules
+		ruleSet := m.rulesCache
 		m.rulesCacheMutex.RUnlock()
 		cacheHits.Inc()
-		return rules, nil
+		return ruleSet, nil
 	}
 	m.rulesCacheMutex.RUnlock()
 	cacheMisses.Inc()
 
-	// Fetch all RegistryRewriteRule resources
+	// Fetch all RegistryRewriteRule and RegistryMirror resources
 	ruleList := &devv1alpha1.RegistryRewriteRuleList{}
 	if err := m.Client.List(ctx, ruleList); err != nil {
 		return nil, fmt.Errorf("failed to list RegistryRewriteRule: %w", err)
 	}
+	mirrorList := &devv1alpha1.RegistryMirrorList{}
+	if err := m.Client.List(ctx, mirrorList); err != nil {
+		return nil, fmt.Errorf("failed to list RegistryMirror: %w", err)
+	}
+
+	parameters := resolveAllParameters(ctx, m.apiReader(), ruleList.Items)
+	ruleSet := &rulesCache{
+		rules: compileRules(ctx, ruleList.Items, parameters, mirrorList.Items...),
+		hash:  ruleSetHash(ruleList.Items, parameters, mirrorList.Items),
+	}
+
+	// Update cache, unless a tag mapping has to be read again
+	if err := resolveTagMappings(ctx, m.apiReader(), ruleSet.rules); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to read tag mappings, not caching rules")
+	} else {
+		m.rulesCacheMutex.Lock()
+		m.rulesCache = ruleSet
+		m.rulesCacheMutex.Unlock()
+	}
+
+	// Update metrics
+	rulesCount.Set(float64(len(ruleSet.rules)))
+
+	return ruleSet, nil
+}
+
+// ruleSetHash returns a short hash identifying the generation of the given
+// RegistryRewriteRule and RegistryMirror resources, and the values of their
+// parameters
+func ruleSetHash(
+	items []devv1alpha1.RegistryRewriteRule, parameters parameterValues, mirrors []devv1alpha1.RegistryMirror,
+) string {
+	keys := make([]string, 0, len(items)+len(mirrors))
+	for _, rr := range items {
+		key := fmt.Sprintf("%s/%s/%d", rr.Name, rr.UID, rr.Generation)
+		if len(rr.Spec.Parameters) > 0 {
+			key += "/" + parametersHash(parameters[rr.Name])
+		}
+		keys = append(keys, key)
+	}
+	for _, rm := range mirrors {
+		keys = append(keys, fmt.Sprintf("mirror/%s/%s/%d", rm.Name, rm.UID, rm.Generation))
+	}
+	sort.Strings(keys)
 
-	// Compile all rules
+	sum := sha256.Sum256([]byte(strings.Join(keys, ",")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// specRules returns the rules of a resource followed by the rules expanded
+// from its preset. Only the rules are returned when the preset is invalid.
+func specRules(rr *devv1alpha1.RegistryRewriteRule) ([]devv1alpha1.Rule, error) {
+	expanded, err := presets.Expand(rr.Spec.Preset)
+	if err != nil {
+		return rr.Spec.Rules, fmt.Errorf("invalid preset: %w", err)
+	}
+	return slices.Concat(rr.Spec.Rules, expanded), nil
+}
+
+// compileRules compiles the rules of the given RegistryRewriteRule and
+// RegistryMirror resources, sorted by priority (higher first). Rules with the
+// same priority keep the order of their resources, mirrors last, so that
+// evaluation is deterministic. Replace templates are rendered with the
+// parameters of their resource, and rules failing to render are skipped.
+func compileRules(
+	ctx context.Context, items []devv1alpha1.RegistryRewriteRule, parameters parameterValues,
+	mirrors ...devv1alpha1.RegistryMirror,
+) []compiledRule {
 	var compiledRules []compiledRule
-	for _, rr := range ruleList.Items {
-		for _, rule := range rr.Spec.Rules {
+	for _, rr := range items {
+		rules, err := specRules(&rr)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Failed to expand preset", "rule", rr.Name)
+		}
+		for i, rule := range rules {
 			regex, err := regexp.Compile(rule.Match)
 			if err != nil {
 				log.FromContext(ctx).Error(err, "Failed to compile regex", "rule", rr.Name, "match", rule.Match)
 				continue
 			}
+			mode := rule.Mode
+			if mode == "" {
+				mode = rr.Spec.Mode
+			}
+			replace, err := renderReplace(rule.Replace, parameters[rr.Name])
+			if err != nil {
+				log.FromContext(ctx).Error(err, "Failed to render replace", "rule", rr.Name, "replace", rule.Replace)
+				continue
+			}
+			if replace == "" && (rule.Tags != nil || rule.MutableTagPolicy != "") {
+				// Keep the matched image, only applying tag rewrites and policies
+				replace = "${0}"
+			}
+			envNames, err := compileEnvVarNames(rule)
+			if err != nil {
+				log.FromContext(ctx).Error(err, "Failed to compile environment variable names", "rule", rr.Name)
+				continue
+			}
 			compiledRules = append(compiledRules, compiledRule{
-				rule:    rule,
-				regex:   regex,
-				replace: rule.Replace,
+				rule:     rule,
+				regex:    regex,
+				replace:  replace,
+				ruleName: rr.Name,
+				index:    i,
+				envNames: envNames,
+				mode:     mode,
+			})
+		}
+	}
+
+	for _, rm := range mirrors {
+		rules, err := mirrorRules(&rm)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Invalid registry mirror", "mirror", rm.Name)
+			continue
+		}
+		for i, rule := range rules {
+			compiledRules = append(compiledRules, compiledRule{
+				rule:     rule,
+				regex:    regexp.MustCompile(rule.Match),
+				replace:  rule.Replace,
+				ruleName: rm.Name,
+				index:    i,
+				mirror:   true,
+				mode:     rm.Spec.Mode,
 			})
 		}
 	}
 
 	// Sort by priority (higher first)
-	sort.Slice(compiledRules, func(i, j int) bool {
+	sort.SliceStable(compiledRules, func(i, j int) bool {
 		return compiledRules[i].rule.Priority > compiledRules[j].rule.Priority
 	})
 
-	// Update cache
-	m.rulesCacheMutex.Lock()
-	m.rulesCache = &rulesCache{rules: compiledRules}
-	m.rulesCacheMutex.Unlock()
-
-	// Update metrics
-	rulesCount.Set(float64(len(compiledRules)))
-
-	return compiledRules, nil
+	return compiledRules
 }
 
-// normalizeImage adds docker.io prefix to images without registry
+// normalizeImage adds docker.io prefix to images without registry. The first
+// component of an image is its registry when it is a host, such as localhost,
+// a domain or a host with its port.
 func normalizeImage(image string) string {
-	// If it's a localhost image, return as-is
-	if regexp.MustCompile(`^localhost(:[0-9]+)?/`).MatchString(image) {
-		return image
-	}
-
-	// Check if it's a docker.io image without namespace
-	if regexp.MustCompile(`^docker\.io/[^/]+`).MatchString(image) {
-		// Extract the image name after docker.io/
-		parts := regexp.MustCompile(`^docker\.io/(.+)`).FindStringSubmatch(image)
-		if len(parts) > 1 && !regexp.MustCompile(`/`).MatchString(parts[1]) {
-			// No slash in the remaining part means it's an official image
-			return "docker.io/library/" + parts[1]
-		}
+	host, remainder, found := strings.Cut(image, "/")
+	if !found {
+		// Image is a Docker official image without namespace, add docker.io/library prefix
+		return "docker.io/library/" + image
 	}
-
-	// If image already has a registry, return as-is
-	if regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9-_.]*\.[a-zA-Z]{2,}/`).MatchString(image) {
-		return image
-	}
-
-	// Check if image already has a namespace (contains /)
-	if regexp.MustCompile(`^[^/]+/`).MatchString(image) {
+	if !isDomain(host) {
 		// Image has namespace, just add docker.io prefix
 		return "docker.io/" + image
 	}
-
-	// Image is a Docker official image without namespace, add docker.io/library prefix
-	return "docker.io/library/" + image
+	if host == "docker.io" && !strings.Contains(remainder, "/") {
+		// No slash in the remaining part means it's an official image
+		return "docker.io/library/" + remainder
+	}
+	return image
 }
 
 // InjectDecoder injects the decoder
@@ -334,17 +879,14 @@ func (m *PodMutator) InvalidateCache() {
 
 // extractRegistry extracts the registry from an image name
 func extractRegistry(image string) string {
-	// Handle localhost
-	if regexp.MustCompile(`^localhost(:[0-9]+)?/`).MatchString(image) {
+	host, _, found := strings.Cut(image, "/")
+	switch {
+	case !found || !isDomain(host):
+		// No registry means docker.io
+		return "docker.io"
+	case host == "localhost" || strings.HasPrefix(host, "localhost:"):
 		return "localhost"
+	default:
+		return host
 	}
-
-	// Check if image has a registry
-	parts := regexp.MustCompile(`^([a-zA-Z0-9][a-zA-Z0-9-_.]*\.[a-zA-Z]{2,})/`).FindStringSubmatch(image)
-	if len(parts) > 1 {
-		return parts[1]
-	}
-
-	// No registry means docker.io
-	return "docker.io"
 }
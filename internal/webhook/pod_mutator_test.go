@@ -18,11 +18,14 @@ package webhook
 
 import (
 	"context"
+	"encoding/json"
 	"regexp"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -30,6 +33,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+	"github.com/flemzord/mutating-registry-webhook/internal/breaker"
 )
 
 var _ = Describe("PodMutator", func() {
@@ -206,9 +210,317 @@ var _ = Describe("PodMutator", func() {
 			result2 := mutator.mutateImage(ctx, "caddy:2.7.6-alpine", rules, pod)
 			Expect(result2).To(Equal("toto.dkr.ecr.eu-west-1.amazonaws.com/dockerhub/library/caddy:2.7.6-alpine"))
 		})
+
+		Context("with images pinned by digest", func() {
+			const digest = "sha256:c5b1261d6d3e43071626931fc004f70149baeba2c8ec672bd4f27761f8e1ad6b"
+
+			var pod *corev1.Pod
+
+			newRules := func(match, replace string, allowDigestChange bool) []compiledRule {
+				return []compiledRule{{
+					rule:    devv1alpha1.Rule{Match: match, Replace: replace, AllowDigestChange: allowDigestChange},
+					regex:   regexp.MustCompile(match),
+					replace: replace,
+				}}
+			}
+
+			BeforeEach(func() {
+				pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"}}
+			})
+
+			It("should keep the digest when the rewrite preserves it", func() {
+				rules := newRules(`^docker\.io/(.*)`, `mirror.local/$1`, false)
+				Expect(mutator.mutateImage(ctx, "nginx@"+digest, rules, pod)).To(Equal("mirror.local/library/nginx@" + digest))
+				Expect(mutator.mutateImage(ctx, "nginx:1.25@"+digest, rules, pod)).
+					To(Equal("mirror.local/library/nginx:1.25@" + digest))
+			})
+
+			It("should refuse rewrites removing or changing the digest", func() {
+				rules := newRules(`^docker\.io/([^@]*).*`, `mirror.local/$1`, false)
+				Expect(mutator.mutateImage(ctx, "nginx:1.25@"+digest, rules, pod)).To(Equal("nginx:1.25@" + digest))
+
+				rewrite, ok := mutator.rewriteImage(ctx, "nginx:1.25@"+digest, rules, pod)
+				Expect(ok).To(BeTrue())
+				Expect(rewrite.err).To(MatchError(ContainSubstring("does not preserve digest")))
+			})
+
+			It("should allow digest changes when the rule opts out", func() {
+				rules := newRules(`^docker\.io/([^@]*).*`, `mirror.local/$1`, true)
+				Expect(mutator.mutateImage(ctx, "nginx:1.25@"+digest, rules, pod)).To(Equal("mirror.local/library/nginx:1.25"))
+			})
+		})
+	})
+
+	Describe("Handle", func() {
+		var pod *corev1.Pod
+
+		withRules := func(rules ...*devv1alpha1.RegistryRewriteRule) {
+			builder := fake.NewClientBuilder().WithScheme(scheme)
+			for _, rr := range rules {
+				builder = builder.WithObjects(rr)
+			}
+			mutator.Client = builder.Build()
+			mutator.InvalidateCache()
+		}
+
+		BeforeEach(func() {
+			pod = &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app", Image: "nginx:1.25"}},
+				},
+			}
+			withRules(&devv1alpha1.RegistryRewriteRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "dockerhub"},
+				Spec: devv1alpha1.RegistryRewriteRuleSpec{
+					Rules: []devv1alpha1.Rule{{Match: `^docker\.io/(.*)`, Replace: `mirror.local/$1`}},
+				},
+			})
+		})
+
+		It("should rewrite container images in enforce mode", func() {
+			resp := mutator.Handle(ctx, newPodRequest(pod))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/library/nginx:1.25"))
+		})
+
+		It("should only record the rewrite for rules in audit mode", func() {
+			withRules(&devv1alpha1.RegistryRewriteRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "dockerhub"},
+				Spec: devv1alpha1.RegistryRewriteRuleSpec{
+					Mode:  devv1alpha1.RewriteModeAudit,
+					Rules: []devv1alpha1.Rule{{Match: `^docker\.io/(.*)`, Replace: `mirror.local/$1`}},
+				},
+			})
+
+			resp := mutator.Handle(ctx, newPodRequest(pod))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(patchValue(resp, "/spec/containers/0/image")).To(BeNil())
+			Expect(patchValue(resp, "/metadata/annotations")).To(HaveKeyWithValue(
+				AuditRewritesAnnotation, `{"app":"mirror.local/library/nginx:1.25"}`))
+		})
+
+		It("should let a rule override the mode of its resource", func() {
+			withRules(&devv1alpha1.RegistryRewriteRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "dockerhub"},
+				Spec: devv1alpha1.RegistryRewriteRuleSpec{
+					Mode: devv1alpha1.RewriteModeAudit,
+					Rules: []devv1alpha1.Rule{{
+						Match:   `^docker\.io/(.*)`,
+						Replace: `mirror.local/$1`,
+						Mode:    devv1alpha1.RewriteModeEnforce,
+					}},
+				},
+			})
+
+			resp := mutator.Handle(ctx, newPodRequest(pod))
+			Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/library/nginx:1.25"))
+		})
+
+		It("should keep the original image when a rule produces an invalid reference", func() {
+			for _, replace := range []string{`mirror.local//$1`, `MIRROR.local/Library/$1`, ``} {
+				withRules(&devv1alpha1.RegistryRewriteRule{
+					ObjectMeta: metav1.ObjectMeta{Name: "dockerhub"},
+					Spec: devv1alpha1.RegistryRewriteRuleSpec{
+						Rules: []devv1alpha1.Rule{{Match: `^docker\.io/library/(.*)`, Replace: replace}},
+					},
+				})
+
+				resp := mutator.Handle(ctx, newPodRequest(pod))
+				Expect(resp.Allowed).To(BeTrue())
+				Expect(resp.Patches).To(BeEmpty(), "replace %q", replace)
+			}
+		})
+
+		It("should apply the global mode override", func() {
+			mutator.Mode = devv1alpha1.RewriteModeAudit
+
+			resp := mutator.Handle(ctx, newPodRequest(pod))
+			Expect(patchValue(resp, "/spec/containers/0/image")).To(BeNil())
+			Expect(patchValue(resp, "/metadata/annotations")).To(HaveKey(AuditRewritesAnnotation))
+		})
+
+		It("should rewrite images with the rules of a preset", func() {
+			withRules(&devv1alpha1.RegistryRewriteRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "ecr"},
+				Spec: devv1alpha1.RegistryRewriteRuleSpec{
+					Preset: &devv1alpha1.Preset{ECRPullThroughCache: &devv1alpha1.ECRPullThroughCachePreset{
+						AccountID: "111122223333",
+						Region:    "eu-west-1",
+						Upstreams: devv1alpha1.Upstreams{DockerHub: "docker-hub"},
+					}},
+				},
+			})
+
+			resp := mutator.Handle(ctx, newPodRequest(pod))
+			Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal(
+				"111122223333.dkr.ecr.eu-west-1.amazonaws.com/docker-hub/library/nginx:1.25"))
+		})
+
+		It("should rewrite the images of upstreams with a port with the mirror preset", func() {
+			withRules(&devv1alpha1.RegistryRewriteRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "mirror"},
+				Spec: devv1alpha1.RegistryRewriteRuleSpec{
+					Preset: &devv1alpha1.Preset{Mirror: &devv1alpha1.MirrorPreset{
+						Registry: "mirror.internal:5000",
+						Upstreams: []devv1alpha1.MirrorUpstream{
+							{Registry: "docker.io"},
+							{Registry: "registry.example.com:5000", Prefix: "example"},
+						},
+					}},
+				},
+			})
+			pod.Spec.Containers = append(pod.Spec.Containers,
+				corev1.Container{Name: "team", Image: "registry.example.com:5000/team/app:1.0"},
+				corev1.Container{Name: "other", Image: "registry.example.com:50001/team/app:1.0"},
+			)
+
+			resp := mutator.Handle(ctx, newPodRequest(pod))
+			Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.internal:5000/library/nginx:1.25"))
+			Expect(patchValue(resp, "/spec/containers/1/image")).To(Equal("mirror.internal:5000/example/team/app:1.0"))
+			Expect(patchValue(resp, "/spec/containers/2/image")).To(BeNil())
+		})
+
+		It("should override the pull policy of the containers rewritten by the rule", func() {
+			withRules(&devv1alpha1.RegistryRewriteRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "dockerhub"},
+				Spec: devv1alpha1.RegistryRewriteRuleSpec{
+					Rules: []devv1alpha1.Rule{{
+						Match:      `^docker\.io/(.*)`,
+						Replace:    `mirror.local/$1`,
+						PullPolicy: corev1.PullIfNotPresent,
+					}},
+				},
+			})
+			pod.Spec.Containers[0].ImagePullPolicy = corev1.PullAlways
+			pod.Spec.InitContainers = []corev1.Container{
+				{Name: "init", Image: "quay.io/prometheus/busybox:latest", ImagePullPolicy: corev1.PullAlways},
+			}
+
+			resp := mutator.Handle(ctx, newPodRequest(pod))
+			Expect(patchValue(resp, "/spec/containers/0/imagePullPolicy")).To(Equal(string(corev1.PullIfNotPresent)))
+			Expect(patchValue(resp, "/spec/initContainers/0/imagePullPolicy")).To(BeNil())
+
+			req := newPodRequest(pod)
+			req.Operation = admissionv1.Update
+			resp = mutator.Handle(ctx, req)
+			Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/library/nginx:1.25"))
+			Expect(patchValue(resp, "/spec/containers/0/imagePullPolicy")).To(BeNil())
+		})
+
+		It("should not override the pull policy of audited rewrites", func() {
+			withRules(&devv1alpha1.RegistryRewriteRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "dockerhub"},
+				Spec: devv1alpha1.RegistryRewriteRuleSpec{
+					Mode: devv1alpha1.RewriteModeAudit,
+					Rules: []devv1alpha1.Rule{{
+						Match:      `^docker\.io/(.*)`,
+						Replace:    `mirror.local/$1`,
+						PullPolicy: corev1.PullNever,
+					}},
+				},
+			})
+
+			resp := mutator.Handle(ctx, newPodRequest(pod))
+			Expect(patchValue(resp, "/spec/containers/0/imagePullPolicy")).To(BeNil())
+		})
+
+		It("should only add image pull secrets to pods being created", func() {
+			withRules(&devv1alpha1.RegistryRewriteRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "dockerhub"},
+				Spec: devv1alpha1.RegistryRewriteRuleSpec{
+					Rules: []devv1alpha1.Rule{{
+						Match:            `^docker\.io/(.*)`,
+						Replace:          `mirror.local/$1`,
+						ImagePullSecrets: []corev1.LocalObjectReference{{Name: "mirror-creds"}},
+					}},
+				},
+			})
+
+			resp := mutator.Handle(ctx, newPodRequest(pod))
+			Expect(patchValue(resp, "/spec/imagePullSecrets")).NotTo(BeNil())
+
+			req := newPodRequest(pod)
+			req.Operation = admissionv1.Update
+			resp = mutator.Handle(ctx, req)
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Patches).To(BeEmpty())
+
+			pod.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "mirror-creds"}}
+			req = newPodRequest(pod)
+			req.Operation = admissionv1.Update
+			resp = mutator.Handle(ctx, req)
+			Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/library/nginx:1.25"))
+			Expect(patchValue(resp, "/spec/imagePullSecrets")).To(BeNil())
+		})
+
+		It("should skip rules suspended by their circuit breaker", func() {
+			withRules(&devv1alpha1.RegistryRewriteRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "dockerhub"},
+				Spec: devv1alpha1.RegistryRewriteRuleSpec{
+					Rules: []devv1alpha1.Rule{
+						{Match: `^docker\.io/(.*)`, Replace: `mirror.local/$1`, Priority: 10},
+						{Match: `^docker\.io/(.*)`, Replace: `fallback.local/$1`},
+					},
+				},
+			})
+			mutator.Breaker = breaker.New(breaker.Config{FailureRate: 1, MinRequests: 1})
+			mutator.Breaker.Record("dockerhub/0", true)
+
+			resp := mutator.Handle(ctx, newPodRequest(pod))
+			Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("fallback.local/library/nginx:1.25"))
+		})
+
+		It("should keep the trial of a half-open circuit for pods being admitted", func() {
+			withRules(&devv1alpha1.RegistryRewriteRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "dockerhub"},
+				Spec: devv1alpha1.RegistryRewriteRuleSpec{
+					Rules: []devv1alpha1.Rule{
+						{Match: `^docker\.io/(.*)`, Replace: `mirror.local/$1`, Priority: 10},
+						{Match: `^docker\.io/(.*)`, Replace: `fallback.local/$1`},
+					},
+				},
+			})
+			mutator.Breaker = breaker.New(breaker.Config{FailureRate: 1, MinRequests: 1, Cooldown: 50 * time.Millisecond})
+			mutator.Breaker.Record("dockerhub/0", true)
+			Eventually(func() breaker.State { return mutator.Breaker.State("dockerhub/0") }).
+				Should(Equal(breaker.StateHalfOpen))
+
+			req := newPodRequest(pod)
+			dryRun := true
+			req.DryRun = &dryRun
+			resp := mutator.Handle(ctx, req)
+			Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("fallback.local/library/nginx:1.25"))
+
+			resp = mutator.Handle(ctx, newPodRequest(pod))
+			Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/library/nginx:1.25"))
+		})
 	})
 })
 
+// newPodRequest returns a create admission request for the pod
+func newPodRequest(pod *corev1.Pod) admission.Request {
+	raw, err := json.Marshal(pod)
+	Expect(err).NotTo(HaveOccurred())
+	return admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Namespace: pod.Namespace,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+}
+
+// patchValue returns the value of the patch operation on path, or nil
+func patchValue(resp admission.Response, path string) any {
+	for _, op := range resp.Patches {
+		if op.Path == path {
+			return op.Value
+		}
+	}
+	return nil
+}
+
 func TestNormalizeImage(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -260,6 +572,16 @@ func TestNormalizeImage(t *testing.T) {
 			input:    "public.ecr.aws/orga/jeffail/benthos",
 			expected: "public.ecr.aws/orga/jeffail/benthos",
 		},
+		{
+			name:     "registry with port",
+			input:    "registry.example.com:5000/team/app:1.0",
+			expected: "registry.example.com:5000/team/app:1.0",
+		},
+		{
+			name:     "single label registry with port",
+			input:    "registry:5000/app",
+			expected: "registry:5000/app",
+		},
 		{
 			name:     "docker.io image without namespace",
 			input:    "docker.io/caddy:2.7.6-alpine",
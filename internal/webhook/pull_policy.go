@@ -0,0 +1,78 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var pullPolicyOverrides = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "registry_rewriter_pull_policy_overrides_total",
+	Help: "Total number of containers whose image pull policy was overridden by a rule",
+}, []string{"rule", "policy"})
+
+func init() {
+	metrics.Registry.MustRegister(pullPolicyOverrides)
+}
+
+// pullPolicyOverride is the image pull policy set on a container by a rule
+type pullPolicyOverride struct {
+	container string
+	from      corev1.PullPolicy
+	to        corev1.PullPolicy
+	rule      compiledRule
+}
+
+// setPullPolicies sets the image pull policy of the rules on the containers
+// they rewrote, and returns the overridden policies. Pull policies can't be
+// changed on existing pods, so it is only called for pods being created.
+func setPullPolicies(pod *corev1.Pod, rewrites []imageRewrite) []pullPolicyOverride {
+	policies := map[string]compiledRule{}
+	for _, r := range rewrites {
+		if r.mode != devv1alpha1.RewriteModeAudit && r.rule.rule.PullPolicy != "" {
+			policies[r.container] = r.rule
+		}
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+
+	var overrides []pullPolicyOverride
+	set := func(name string, policy *corev1.PullPolicy) {
+		rule, ok := policies[name]
+		if !ok || *policy == rule.rule.PullPolicy {
+			return
+		}
+		overrides = append(overrides, pullPolicyOverride{container: name, from: *policy, to: rule.rule.PullPolicy, rule: rule})
+		*policy = rule.rule.PullPolicy
+		pullPolicyOverrides.WithLabelValues(ruleRef(rule), string(rule.rule.PullPolicy)).Inc()
+	}
+	for i := range pod.Spec.Containers {
+		set(pod.Spec.Containers[i].Name, &pod.Spec.Containers[i].ImagePullPolicy)
+	}
+	for i := range pod.Spec.InitContainers {
+		set(pod.Spec.InitContainers[i].Name, &pod.Spec.InitContainers[i].ImagePullPolicy)
+	}
+	for i := range pod.Spec.EphemeralContainers {
+		set(pod.Spec.EphemeralContainers[i].Name, &pod.Spec.EphemeralContainers[i].ImagePullPolicy)
+	}
+	return overrides
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+// errMissingPullSecret is returned when an image pull secret required by a
+// rule doesn't exist in the namespace of the pod
+var errMissingPullSecret = fmt.Errorf("image pull secret not found")
+
+// checkImagePullSecrets checks that the image pull secrets of a rule exist in
+// the namespace of the pod
+func (m *PodMutator) checkImagePullSecrets(ctx context.Context, rule compiledRule, namespace string) error {
+	for _, ref := range rule.rule.ImagePullSecrets {
+		key := types.NamespacedName{Namespace: namespace, Name: ref.Name}
+		if err := m.apiReader().Get(ctx, key, &corev1.Secret{}); err != nil {
+			if errors.IsNotFound(err) {
+				return fmt.Errorf("%w: %s", errMissingPullSecret, key)
+			}
+			return fmt.Errorf("failed to get image pull secret %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// missingImagePullSecrets returns the image pull secrets of the rule of an
+// enforced rewrite that the pod doesn't have
+func missingImagePullSecrets(pod *corev1.Pod, rewrite imageRewrite) []string {
+	if rewrite.mode == devv1alpha1.RewriteModeAudit {
+		return nil
+	}
+	var missing []string
+	for _, ref := range rewrite.rule.rule.ImagePullSecrets {
+		if !slices.Contains(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: ref.Name}) {
+			missing = append(missing, ref.Name)
+		}
+	}
+	return missing
+}
+
+// addImagePullSecrets adds to the pod the image pull secrets of the rules that
+// rewrote its images, keeping the existing ones, and returns the added names
+func addImagePullSecrets(pod *corev1.Pod, rewrites []imageRewrite) []string {
+	present := map[string]bool{}
+	for _, ref := range pod.Spec.ImagePullSecrets {
+		present[ref.Name] = true
+	}
+
+	var added []string
+	for _, r := range rewrites {
+		if r.mode == devv1alpha1.RewriteModeAudit {
+			continue
+		}
+		for _, ref := range r.rule.rule.ImagePullSecrets {
+			if present[ref.Name] {
+				continue
+			}
+			present[ref.Name] = true
+			pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: ref.Name})
+			added = append(added, ref.Name)
+		}
+	}
+	return added
+}
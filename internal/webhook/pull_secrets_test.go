@@ -0,0 +1,107 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"regexp"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var _ = Describe("Image pull secrets", func() {
+	var (
+		ctx     context.Context
+		mutator *PodMutator
+		pod     *corev1.Pod
+		rule    compiledRule
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		mutator = &PodMutator{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "mirror-creds", Namespace: "default"},
+			}).Build(),
+		}
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				ImagePullSecrets: []corev1.LocalObjectReference{{Name: "existing"}},
+			},
+		}
+		rule = compiledRule{
+			ruleName: "mirror",
+			regex:    regexp.MustCompile(`^docker\.io/(.*)`),
+			replace:  "mirror.local/$1",
+			rule: devv1alpha1.Rule{
+				ImagePullSecrets: []corev1.LocalObjectReference{{Name: "mirror-creds"}, {Name: "existing"}},
+			},
+		}
+	})
+
+	It("should add the secrets of the rules without duplicating them", func() {
+		other := rule
+		other.rule.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "mirror-creds"}, {Name: "other-creds"}}
+
+		added := addImagePullSecrets(pod, []imageRewrite{{rule: rule}, {rule: other}})
+		Expect(added).To(Equal([]string{"mirror-creds", "other-creds"}))
+		Expect(pod.Spec.ImagePullSecrets).To(Equal([]corev1.LocalObjectReference{
+			{Name: "existing"}, {Name: "mirror-creds"}, {Name: "other-creds"},
+		}))
+	})
+
+	It("should not add the secrets of audited rewrites", func() {
+		added := addImagePullSecrets(pod, []imageRewrite{{rule: rule, mode: devv1alpha1.RewriteModeAudit}})
+		Expect(added).To(BeEmpty())
+		Expect(pod.Spec.ImagePullSecrets).To(HaveLen(1))
+	})
+
+	It("should rewrite when the required secrets exist", func() {
+		rule.rule.ImagePullSecrets = rule.rule.ImagePullSecrets[:1]
+		rule.rule.RequireImagePullSecrets = true
+
+		rewrite, ok := mutator.rewriteImage(ctx, "nginx:1.25", []compiledRule{rule}, pod)
+		Expect(ok).To(BeTrue())
+		Expect(rewrite.err).NotTo(HaveOccurred())
+		Expect(rewrite.rewritten).To(Equal("mirror.local/library/nginx:1.25"))
+	})
+
+	It("should skip the rewrite when a required secret is missing", func() {
+		rule.rule.RequireImagePullSecrets = true
+
+		rewrite, ok := mutator.rewriteImage(ctx, "nginx:1.25", []compiledRule{rule}, pod)
+		Expect(ok).To(BeTrue())
+		Expect(rewrite.err).To(MatchError(errMissingPullSecret))
+		Expect(mutator.mutateImage(ctx, "nginx:1.25", []compiledRule{rule}, pod)).To(Equal("nginx:1.25"))
+	})
+
+	It("should not check the secrets unless required", func() {
+		rewrite, ok := mutator.rewriteImage(ctx, "nginx:1.25", []compiledRule{rule}, pod)
+		Expect(ok).To(BeTrue())
+		Expect(rewrite.err).NotTo(HaveOccurred())
+	})
+})
@@ -0,0 +1,124 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxNameLength is the maximum length of the name of an image reference
+const maxNameLength = 255
+
+// Grammar of image references, as defined by the distribution project
+var (
+	domainRegexp = regexp.MustCompile(
+		`^(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*|\[[a-fA-F0-9:]+\])` +
+			`(?::[0-9]+)?$`)
+	pathComponentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+	tagRegexp           = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestRegexp        = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+)
+
+// imageReference is a parsed image reference
+type imageReference struct {
+	// domain is the registry host, with its port, or empty when the
+	// reference has none
+	domain string
+	// path is the repository path within the registry
+	path   string
+	tag    string
+	digest string
+}
+
+// name returns the repository name, including the domain
+func (r imageReference) name() string {
+	if r.domain == "" {
+		return r.path
+	}
+	return r.domain + "/" + r.path
+}
+
+// String returns the reference in its canonical form
+func (r imageReference) String() string {
+	s := r.name()
+	if r.tag != "" {
+		s += ":" + r.tag
+	}
+	if r.digest != "" {
+		s += "@" + r.digest
+	}
+	return s
+}
+
+// parseReference parses and validates an image reference such as
+// registry.example.com:5000/team/app:1.0@sha256:...
+func parseReference(s string) (imageReference, error) {
+	var ref imageReference
+	if s == "" {
+		return ref, errors.New("empty reference")
+	}
+
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.digest = name[i+1:]
+		name = name[:i]
+		if !digestRegexp.MatchString(ref.digest) {
+			return ref, fmt.Errorf("invalid digest %q", ref.digest)
+		}
+	}
+	// A tag follows the last colon, unless that colon belongs to the domain
+	if i := strings.LastIndex(name, ":"); i >= 0 && !strings.Contains(name[i+1:], "/") {
+		ref.tag = name[i+1:]
+		name = name[:i]
+		if !tagRegexp.MatchString(ref.tag) {
+			return ref, fmt.Errorf("invalid tag %q", ref.tag)
+		}
+	}
+
+	if name == "" {
+		return ref, errors.New("empty repository name")
+	}
+	if len(name) > maxNameLength {
+		return ref, fmt.Errorf("repository name longer than %d characters", maxNameLength)
+	}
+
+	ref.path = name
+	if i := strings.Index(name, "/"); i >= 0 && isDomain(name[:i]) {
+		ref.domain = name[:i]
+		ref.path = name[i+1:]
+		if !domainRegexp.MatchString(ref.domain) {
+			return ref, fmt.Errorf("invalid domain %q", ref.domain)
+		}
+	}
+
+	for _, component := range strings.Split(ref.path, "/") {
+		if !pathComponentRegexp.MatchString(component) {
+			return ref, fmt.Errorf("invalid repository path %q", ref.path)
+		}
+	}
+
+	return ref, nil
+}
+
+// isDomain reports whether the first component of a name is a registry host
+func isDomain(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost" ||
+		strings.ToLower(component) != component
+}
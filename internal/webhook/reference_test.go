@@ -0,0 +1,107 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"strings"
+	"testing"
+)
+
+const testDigest = "sha256:c5b1261d6d3e43071626931fc004f70149baeba2c8ec672bd4f27761f8e1ad6b"
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected imageReference
+	}{
+		{
+			name:     "official image",
+			input:    "nginx",
+			expected: imageReference{path: "nginx"},
+		},
+		{
+			name:     "image with registry and tag",
+			input:    "docker.io/library/nginx:1.25",
+			expected: imageReference{domain: "docker.io", path: "library/nginx", tag: "1.25"},
+		},
+		{
+			name:     "registry with port",
+			input:    "registry.example.com:5000/team/app:v1",
+			expected: imageReference{domain: "registry.example.com:5000", path: "team/app", tag: "v1"},
+		},
+		{
+			name:     "localhost without tag",
+			input:    "localhost:5000/app",
+			expected: imageReference{domain: "localhost:5000", path: "app"},
+		},
+		{
+			name:     "digest",
+			input:    "quay.io/org/app@" + testDigest,
+			expected: imageReference{domain: "quay.io", path: "org/app", digest: testDigest},
+		},
+		{
+			name:     "tag and digest",
+			input:    "ghcr.io/org/app:1.0@" + testDigest,
+			expected: imageReference{domain: "ghcr.io", path: "org/app", tag: "1.0", digest: testDigest},
+		},
+		{
+			name:     "namespace without registry",
+			input:    "special-registry/nginx",
+			expected: imageReference{path: "special-registry/nginx"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref, err := parseReference(tt.input)
+			if err != nil {
+				t.Fatalf("parseReference(%q) returned error: %v", tt.input, err)
+			}
+			if ref != tt.expected {
+				t.Errorf("parseReference(%q) = %+v, want %+v", tt.input, ref, tt.expected)
+			}
+			if ref.String() != tt.input {
+				t.Errorf("parseReference(%q).String() = %q", tt.input, ref.String())
+			}
+		})
+	}
+}
+
+func TestParseReferenceErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "empty", input: ""},
+		{name: "double slash", input: "mirror.local//library/nginx:1.25"},
+		{name: "uppercase repository", input: "mirror.local/Library/nginx:1.25"},
+		{name: "trailing slash", input: "mirror.local/"},
+		{name: "invalid tag", input: "mirror.local/nginx:-1.25"},
+		{name: "invalid digest", input: "mirror.local/nginx@sha256:abc"},
+		{name: "invalid domain", input: "-mirror.local/nginx"},
+		{name: "name too long", input: "mirror.local/" + strings.Repeat("a", maxNameLength)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ref, err := parseReference(tt.input); err == nil {
+				t.Errorf("parseReference(%q) = %+v, want error", tt.input, ref)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+	"github.com/flemzord/mutating-registry-webhook/internal/presets"
+)
+
+// DefaultMirrorStatsInterval is the default interval between two updates of
+// the served pods of the RegistryMirror resources
+const DefaultMirrorStatsInterval = 30 * time.Second
+
+// mirrorRefPrefix prefixes the references of the rules of RegistryMirror
+// resources, whose names may be those of RegistryRewriteRule resources
+const mirrorRefPrefix = "RegistryMirror"
+
+var mirrorServedPods = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "registry_rewriter_mirror_served_pods_total",
+	Help: "Total number of pods whose images were redirected by a RegistryMirror",
+}, []string{"mirror"})
+
+func init() {
+	metrics.Registry.MustRegister(mirrorServedPods)
+}
+
+// mirrorRules returns the rules redirecting the sources of a mirror to its
+// target, one per source
+func mirrorRules(rm *devv1alpha1.RegistryMirror) ([]devv1alpha1.Rule, error) {
+	upstreams := make([]devv1alpha1.MirrorUpstream, 0, len(rm.Spec.Sources))
+	for _, source := range rm.Spec.Sources {
+		upstreams = append(upstreams, devv1alpha1.MirrorUpstream{Registry: source, Prefix: rm.Spec.PathPrefix})
+	}
+	rules, err := presets.Expand(&devv1alpha1.Preset{Mirror: &devv1alpha1.MirrorPreset{
+		Registry:  rm.Spec.Target,
+		Upstreams: upstreams,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		rules[i].Priority = rm.Spec.Priority
+	}
+	return rules, nil
+}
+
+// RegistryMirrorStats counts the pods served by each RegistryMirror and
+// periodically adds them to their status. It runs on every replica, each one
+// adding the pods it served.
+type RegistryMirrorStats struct {
+	client   client.Client
+	interval time.Duration
+
+	mu         sync.Mutex
+	served     map[string]int64
+	lastServed map[string]time.Time
+}
+
+// NewRegistryMirrorStats returns RegistryMirrorStats updating the status of
+// the mirrors every interval
+func NewRegistryMirrorStats(c client.Client, interval time.Duration) *RegistryMirrorStats {
+	if interval <= 0 {
+		interval = DefaultMirrorStatsInterval
+	}
+	return &RegistryMirrorStats{
+		client:     c,
+		interval:   interval,
+		served:     map[string]int64{},
+		lastServed: map[string]time.Time{},
+	}
+}
+
+// Start updates the status of the mirrors until the context is done, and
+// once more before returning
+func (s *RegistryMirrorStats) Start(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			s.flush(flushCtx)
+			return nil
+		case <-ticker.C:
+			s.flush(ctx)
+		}
+	}
+}
+
+// NeedLeaderElection returns false, since every replica serves pods
+func (s *RegistryMirrorStats) NeedLeaderElection() bool {
+	return false
+}
+
+// recordServed counts a pod for each mirror that redirected one of its
+// images. A nil RegistryMirrorStats records nothing.
+func (s *RegistryMirrorStats) recordServed(rewrites []imageRewrite) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	counted := map[string]bool{}
+	for _, r := range rewrites {
+		if !r.rule.mirror || r.mode == devv1alpha1.RewriteModeAudit || counted[r.rule.ruleName] {
+			continue
+		}
+		counted[r.rule.ruleName] = true
+		s.served[r.rule.ruleName]++
+		s.lastServed[r.rule.ruleName] = now
+		mirrorServedPods.WithLabelValues(r.rule.ruleName).Inc()
+	}
+}
+
+// flush adds the pods served since the last flush to the status of the
+// mirrors. Counts that fail to be added are kept for the next flush.
+func (s *RegistryMirrorStats) flush(ctx context.Context) {
+	s.mu.Lock()
+	served, lastServed := s.served, s.lastServed
+	s.served, s.lastServed = map[string]int64{}, map[string]time.Time{}
+	s.mu.Unlock()
+
+	logger := log.FromContext(ctx)
+	for name, count := range served {
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			rm := &devv1alpha1.RegistryMirror{}
+			if err := s.client.Get(ctx, types.NamespacedName{Name: name}, rm); err != nil {
+				return err
+			}
+			rm.Status.ServedPods += count
+			last := metav1.NewTime(lastServed[name])
+			rm.Status.LastServedTime = &last
+			return s.client.Status().Update(ctx, rm)
+		})
+		switch {
+		case errors.IsNotFound(err):
+		case err != nil:
+			logger.Error(err, "Failed to update served pods of registry mirror", "mirror", name)
+			s.mu.Lock()
+			s.served[name] += count
+			if s.lastServed[name].IsZero() {
+				s.lastServed[name] = lastServed[name]
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// RegistryMirrorWatcher invalidates the rules cache when RegistryMirror
+// resources change, and reports whether they are valid
+type RegistryMirrorWatcher struct {
+	client.Client
+	Mutator *PodMutator
+}
+
+// +kubebuilder:rbac:groups=dev.flemzord.fr,resources=registrymirrors,verbs=get;list;watch
+// +kubebuilder:rbac:groups=dev.flemzord.fr,resources=registrymirrors/status,verbs=get;update;patch
+
+// Reconcile handles changes to RegistryMirror resources
+func (r *RegistryMirrorWatcher) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	logger := log.FromContext(ctx)
+	logger.Info("RegistryMirror changed, invalidating cache", "name", req.Name)
+	r.Mutator.InvalidateCache()
+
+	rm := &devv1alpha1.RegistryMirror{}
+	if err := r.Get(ctx, req.NamespacedName, rm); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+
+	condition := metav1.Condition{
+		Type:               devv1alpha1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: rm.Generation,
+		Reason:             "Valid",
+		Message:            "The mirror is used by the webhook",
+	}
+	if _, err := mirrorRules(rm); err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Invalid"
+		condition.Message = err.Error()
+	}
+	rm.Status.ObservedGeneration = rm.Generation
+	meta.SetStatusCondition(&rm.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, rm); err != nil {
+		logger.Error(err, "Failed to update RegistryMirror status", "name", req.Name)
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{}, nil
+}
+
+// SetupWithManager sets up the watcher with the Manager
+func (r *RegistryMirrorWatcher) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&devv1alpha1.RegistryMirror{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Named("registrymirror").
+		Complete(r)
+}
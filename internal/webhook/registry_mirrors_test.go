@@ -0,0 +1,159 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var _ = Describe("RegistryMirror", func() {
+	var (
+		ctx     context.Context
+		c       client.Client
+		mutator *PodMutator
+		pod     *corev1.Pod
+	)
+
+	newMirror := func(name string, priority int, sources ...string) *devv1alpha1.RegistryMirror {
+		return &devv1alpha1.RegistryMirror{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: devv1alpha1.RegistryMirrorSpec{
+				Sources:    sources,
+				Target:     "mirror.local:5000",
+				PathPrefix: "cache",
+				Priority:   priority,
+			},
+		}
+	}
+
+	setup := func(objs ...client.Object) {
+		mutator = newTestMutator(objs...)
+		c = mutator.Client
+		mutator.MirrorStats = NewRegistryMirrorStats(c, 0)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{Name: "app", Image: "nginx:1.25"},
+					{Name: "metrics", Image: "quay.io/prometheus/node-exporter:v1.7.0"},
+				},
+			},
+		}
+	})
+
+	It("should redirect the images of the sources to the target", func() {
+		setup(newMirror("public", 0, "docker.io", "quay.io"))
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local:5000/cache/library/nginx:1.25"))
+		Expect(patchValue(resp, "/spec/containers/1/image")).To(Equal(
+			"mirror.local:5000/cache/prometheus/node-exporter:v1.7.0"))
+		annotations, ok := patchValue(resp, "/metadata/annotations").(map[string]any)
+		Expect(ok).To(BeTrue())
+		records, err := ParseOriginalImages(map[string]string{
+			OriginalImagesAnnotation: annotations[OriginalImagesAnnotation].(string),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records["app"].RuleRef()).To(Equal("RegistryMirror/public/0"))
+		Expect(records["metrics"].RuleRef()).To(Equal("RegistryMirror/public/1"))
+	})
+
+	It("should redirect the images of sources with a port", func() {
+		setup(newMirror("internal", 0, "registry.example.com:5000", "docker.io"))
+		pod.Spec.Containers[1].Image = "registry.example.com:5000/team/app:1.0"
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local:5000/cache/library/nginx:1.25"))
+		Expect(patchValue(resp, "/spec/containers/1/image")).To(Equal("mirror.local:5000/cache/team/app:1.0"))
+	})
+
+	It("should evaluate mirrors by priority, after the rules of equal priority", func() {
+		rule := &devv1alpha1.RegistryRewriteRule{
+			ObjectMeta: metav1.ObjectMeta{Name: "dockerhub"},
+			Spec: devv1alpha1.RegistryRewriteRuleSpec{
+				Rules: []devv1alpha1.Rule{{Match: `^docker\.io/(.*)`, Replace: `rules.local/$1`, Priority: 5}},
+			},
+		}
+		setup(rule, newMirror("low", 5, "docker.io"), newMirror("high", 10, "quay.io"))
+
+		rules, err := mutator.getRules(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		refs := make([]string, 0, len(rules.rules))
+		for _, r := range rules.rules {
+			refs = append(refs, ruleRef(r))
+		}
+		Expect(refs).To(Equal([]string{"RegistryMirror/high/0", "dockerhub/0", "RegistryMirror/low/0"}))
+		Expect(mutator.mutateImage(ctx, "nginx:1.25", rules.rules, pod)).To(Equal("rules.local/library/nginx:1.25"))
+	})
+
+	It("should record the pods served by each mirror in its status", func() {
+		setup(newMirror("public", 0, "docker.io", "quay.io"))
+
+		mutator.Handle(ctx, newPodRequest(pod))
+		mutator.Handle(ctx, newPodRequest(pod))
+		dryRun := true
+		req := newPodRequest(pod)
+		req.DryRun = &dryRun
+		mutator.Handle(ctx, req)
+		mutator.MirrorStats.flush(ctx)
+
+		mirror := &devv1alpha1.RegistryMirror{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "public"}, mirror)).To(Succeed())
+		Expect(mirror.Status.ServedPods).To(Equal(int64(2)))
+		Expect(mirror.Status.LastServedTime).NotTo(BeNil())
+
+		mutator.MirrorStats.flush(ctx)
+		Expect(c.Get(ctx, types.NamespacedName{Name: "public"}, mirror)).To(Succeed())
+		Expect(mirror.Status.ServedPods).To(Equal(int64(2)))
+	})
+
+	It("should report invalid mirrors", func() {
+		invalid := newMirror("invalid", 0, "docker.io/library")
+		setup(invalid, newMirror("valid", 0, "docker.io"))
+		watcher := &RegistryMirrorWatcher{Client: c, Mutator: mutator}
+
+		for _, name := range []string{"invalid", "valid"} {
+			key := types.NamespacedName{Name: name}
+			_, err := watcher.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		mirror := &devv1alpha1.RegistryMirror{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "invalid"}, mirror)).To(Succeed())
+		Expect(meta.IsStatusConditionFalse(mirror.Status.Conditions, devv1alpha1.ConditionReady)).To(BeTrue())
+		Expect(c.Get(ctx, types.NamespacedName{Name: "valid"}, mirror)).To(Succeed())
+		Expect(meta.IsStatusConditionTrue(mirror.Status.Conditions, devv1alpha1.ConditionReady)).To(BeTrue())
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local:5000/cache/library/nginx:1.25"))
+	})
+})
@@ -0,0 +1,218 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+// auditAnnotationRewrites is the audit annotation key listing the rewrites
+// applied to a pod. The API server prefixes it with the webhook name.
+const auditAnnotationRewrites = "image-rewrites"
+
+// rewriteRecord is the serialized form of an imageRewrite
+type rewriteRecord struct {
+	Container string                  `json:"container"`
+	Original  string                  `json:"original"`
+	Rewritten string                  `json:"rewritten"`
+	Rule      string                  `json:"rule"`
+	Index     int                     `json:"index"`
+	Mirror    bool                    `json:"mirror,omitempty"`
+	Mode      devv1alpha1.RewriteMode `json:"mode"`
+}
+
+// record returns the serialized form of the rewrite
+func (r imageRewrite) record() rewriteRecord {
+	return rewriteRecord{
+		Container: r.container,
+		Original:  r.original,
+		Rewritten: r.rewritten,
+		Rule:      r.rule.ruleName,
+		Index:     r.rule.index,
+		Mirror:    r.rule.mirror,
+		Mode:      r.mode,
+	}
+}
+
+// describeRewrites adds admission warnings and audit annotations describing
+// the rewrites to the response, unless disabled for the pod namespace
+func (m *PodMutator) describeRewrites(resp admission.Response, pod *corev1.Pod, rewrites []imageRewrite) admission.Response {
+	if len(rewrites) == 0 || slices.Contains(m.QuietNamespaces, pod.Namespace) {
+		return resp
+	}
+
+	if m.AdmissionWarnings {
+		for _, r := range rewrites {
+			verb := "rewritten"
+			if r.mode == devv1alpha1.RewriteModeAudit {
+				verb = "would be rewritten (audit)"
+			}
+			resp = resp.WithWarnings(fmt.Sprintf("image %s %s to %s by rule %s",
+				r.original, verb, r.rewritten, ruleRef(r.rule)))
+		}
+	}
+
+	if m.AuditAnnotations {
+		records := make([]rewriteRecord, 0, len(rewrites))
+		for _, r := range rewrites {
+			records = append(records, r.record())
+		}
+		value, err := json.Marshal(records)
+		if err == nil {
+			if resp.AuditAnnotations == nil {
+				resp.AuditAnnotations = map[string]string{}
+			}
+			resp.AuditAnnotations[auditAnnotationRewrites] = string(value)
+		}
+	}
+
+	return resp
+}
+
+// ImageRecord describes, in the OriginalImagesAnnotation, the rewrite of a
+// container image
+type ImageRecord struct {
+	Original  string `json:"original"`
+	Rewritten string `json:"rewritten"`
+	Rule      string `json:"rule"`
+	Index     int    `json:"index"`
+	// Mirror is set when Rule is the name of a RegistryMirror
+	Mirror bool `json:"mirror,omitempty"`
+}
+
+// RuleRef returns the reference of the rule that rewrote the image, in the
+// form used by logs, metrics and circuit breakers
+func (r ImageRecord) RuleRef() string {
+	return ruleRef(compiledRule{ruleName: r.Rule, index: r.Index, mirror: r.Mirror})
+}
+
+// ParseOriginalImages returns the image records of the OriginalImagesAnnotation
+// of the given annotations, keyed by container name
+func ParseOriginalImages(annotations map[string]string) (map[string]ImageRecord, error) {
+	records := map[string]ImageRecord{}
+	value, ok := annotations[OriginalImagesAnnotation]
+	if !ok {
+		return records, nil
+	}
+	if err := json.Unmarshal([]byte(value), &records); err != nil {
+		return nil, fmt.Errorf("failed to parse %s annotation: %w", OriginalImagesAnnotation, err)
+	}
+	return records, nil
+}
+
+// annotateRewrites records the enforced rewrites, the tags of pinned images
+// and the rule set hash in the pod annotations, and sets the RewrittenLabel.
+// Valid records of containers not rewritten by this admission, for instance
+// on update, are kept.
+func annotateRewrites(pod *corev1.Pod, rewrites []imageRewrite, hash string) error {
+	records, err := ParseOriginalImages(pod.Annotations)
+	if err != nil {
+		records = map[string]ImageRecord{}
+	}
+	pinned := map[string]string{}
+	if value, ok := pod.Annotations[PinnedTagsAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &pinned); err != nil {
+			pinned = map[string]string{}
+		}
+	}
+	for _, r := range rewrites {
+		if r.mode == devv1alpha1.RewriteModeAudit {
+			continue
+		}
+		records[r.container] = ImageRecord{
+			Original:  r.original,
+			Rewritten: r.rewritten,
+			Rule:      r.rule.ruleName,
+			Index:     r.rule.index,
+			Mirror:    r.rule.mirror,
+		}
+		if r.pinnedFrom != "" {
+			pinned[r.container] = r.pinnedFrom
+		} else {
+			delete(pinned, r.container)
+		}
+	}
+
+	value, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[OriginalImagesAnnotation] = string(value)
+	if len(pinned) > 0 {
+		value, err := json.Marshal(pinned)
+		if err != nil {
+			return err
+		}
+		pod.Annotations[PinnedTagsAnnotation] = string(value)
+	} else {
+		delete(pod.Annotations, PinnedTagsAnnotation)
+	}
+	if hash != "" {
+		pod.Annotations[RuleSetHashAnnotation] = hash
+	}
+	if len(records) > 0 {
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		pod.Labels[RewrittenLabel] = "true"
+	}
+	return nil
+}
+
+// annotateImageRecords records rewrites in the given annotation of a pod or
+// another object, as a JSON map of the names of their containers to
+// ImageRecord, keeping the records of names not rewritten by this admission
+func annotateImageRecords(obj metav1.Object, annotation string, rewrites []imageRewrite) error {
+	annotations := obj.GetAnnotations()
+	records := map[string]ImageRecord{}
+	if value, ok := annotations[annotation]; ok {
+		if err := json.Unmarshal([]byte(value), &records); err != nil {
+			records = map[string]ImageRecord{}
+		}
+	}
+	for _, r := range rewrites {
+		records[r.container] = ImageRecord{
+			Original:  r.original,
+			Rewritten: r.rewritten,
+			Rule:      r.rule.ruleName,
+			Index:     r.rule.index,
+			Mirror:    r.rule.mirror,
+		}
+	}
+
+	value, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[annotation] = string(value)
+	obj.SetAnnotations(annotations)
+	return nil
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var _ = Describe("Rewrite reporting", func() {
+	var (
+		mutator *PodMutator
+		pod     *corev1.Pod
+		ctx     context.Context
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(devv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		mutator = &PodMutator{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&devv1alpha1.RegistryRewriteRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "dockerhub"},
+				Spec: devv1alpha1.RegistryRewriteRuleSpec{
+					Rules: []devv1alpha1.Rule{{Match: `^docker\.io/(.*)`, Replace: `mirror.local/$1`}},
+				},
+			}).Build(),
+			AdmissionWarnings: true,
+			AuditAnnotations:  true,
+		}
+		Expect(mutator.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Image: "nginx:1.25"}},
+			},
+		}
+	})
+
+	It("should return admission warnings describing the rewrites", func() {
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(resp.Warnings).To(ConsistOf("image nginx:1.25 rewritten to mirror.local/library/nginx:1.25 by rule dockerhub/0"))
+	})
+
+	It("should describe audited rewrites in warnings", func() {
+		mutator.Mode = devv1alpha1.RewriteModeAudit
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(resp.Warnings).To(ConsistOf(
+			"image nginx:1.25 would be rewritten (audit) to mirror.local/library/nginx:1.25 by rule dockerhub/0"))
+	})
+
+	It("should record the rewrites in audit annotations", func() {
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(resp.AuditAnnotations).To(HaveKey(auditAnnotationRewrites))
+
+		var records []rewriteRecord
+		Expect(json.Unmarshal([]byte(resp.AuditAnnotations[auditAnnotationRewrites]), &records)).To(Succeed())
+		Expect(records).To(ConsistOf(rewriteRecord{
+			Container: "app",
+			Original:  "nginx:1.25",
+			Rewritten: "mirror.local/library/nginx:1.25",
+			Rule:      "dockerhub",
+			Index:     0,
+			Mode:      devv1alpha1.RewriteModeEnforce,
+		}))
+	})
+
+	It("should honor the configuration", func() {
+		mutator.AdmissionWarnings = false
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(resp.Warnings).To(BeEmpty())
+		Expect(resp.AuditAnnotations).NotTo(BeEmpty())
+
+		mutator.AdmissionWarnings = true
+		mutator.QuietNamespaces = []string{"default"}
+		resp = mutator.Handle(ctx, newPodRequest(pod))
+		Expect(resp.Warnings).To(BeEmpty())
+		Expect(resp.AuditAnnotations).To(BeEmpty())
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/library/nginx:1.25"))
+	})
+
+	It("should annotate the pod with the original images and the rule set hash", func() {
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		annotations, ok := patchValue(resp, "/metadata/annotations").(map[string]any)
+		Expect(ok).To(BeTrue())
+		Expect(annotations).To(HaveKeyWithValue(RuleSetHashAnnotation, MatchRegexp(`^[0-9a-f]{16}$`)))
+
+		value, ok := annotations[OriginalImagesAnnotation].(string)
+		Expect(ok).To(BeTrue())
+		records, err := ParseOriginalImages(map[string]string{OriginalImagesAnnotation: value})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(Equal(map[string]ImageRecord{
+			"app": {Original: "nginx:1.25", Rewritten: "mirror.local/library/nginx:1.25", Rule: "dockerhub", Index: 0},
+		}))
+	})
+
+	It("should keep the records of containers rewritten earlier", func() {
+		pod.Annotations = map[string]string{
+			OriginalImagesAnnotation: `{"sidecar":{"original":"envoy:1.29","rewritten":"mirror.local/library/envoy:1.29",` +
+				`"rule":"dockerhub","index":0}}`,
+		}
+		Expect(annotateRewrites(pod, []imageRewrite{{
+			container: "app",
+			original:  "nginx:1.25",
+			rewritten: "mirror.local/library/nginx:1.25",
+			rule:      compiledRule{ruleName: "dockerhub"},
+		}}, "abc")).To(Succeed())
+
+		records, err := ParseOriginalImages(pod.Annotations)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveKey("sidecar"))
+		Expect(records).To(HaveKey("app"))
+		Expect(pod.Annotations).To(HaveKeyWithValue(RuleSetHashAnnotation, "abc"))
+		Expect(pod.Labels).To(HaveKeyWithValue(RewrittenLabel, "true"))
+	})
+
+	It("should not record audited rewrites as original images", func() {
+		mutator.Mode = devv1alpha1.RewriteModeAudit
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		annotations, ok := patchValue(resp, "/metadata/annotations").(map[string]any)
+		Expect(ok).To(BeTrue())
+		Expect(annotations).NotTo(HaveKey(OriginalImagesAnnotation))
+		Expect(patchValue(resp, "/metadata/labels")).To(BeNil())
+	})
+})
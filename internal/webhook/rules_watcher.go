@@ -18,20 +18,42 @@ package webhook
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 
-	"k8s.io/apimachinery/pkg/api/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+	"github.com/flemzord/mutating-registry-webhook/internal/breaker"
 )
 
 // RulesWatcher watches for changes to RegistryRewriteRule resources
 type RulesWatcher struct {
 	client.Client
 	Mutator *PodMutator
+	// SampleImages is the image corpus used to detect shadowed and
+	// conflicting rules. DefaultSampleImages is used when empty.
+	SampleImages []string
+	// Prober, when set, reports the health of the targets of the rules as
+	// conditions
+	Prober *MirrorProber
+	// Breaker, when set, reports the rules suspended by their circuit
+	// breaker as conditions
+	Breaker *breaker.Breaker
 }
 
 // Reconcile handles changes to RegistryRewriteRule resources
@@ -43,7 +65,7 @@ func (r *RulesWatcher) Reconcile(ctx context.Context, req reconcile.Request) (re
 	rule := &devv1alpha1.RegistryRewriteRule{}
 	err := r.Get(ctx, req.NamespacedName, rule)
 	if err != nil {
-		if errors.IsNotFound(err) {
+		if apierrors.IsNotFound(err) {
 			// Resource was deleted
 			logger.Info("RegistryRewriteRule deleted", "name", req.Name)
 		} else {
@@ -55,11 +77,33 @@ func (r *RulesWatcher) Reconcile(ctx context.Context, req reconcile.Request) (re
 	// Invalidate the cache
 	r.Mutator.InvalidateCache()
 
+	// Analyze the global rule set for shadowed and conflicting rules, also
+	// on deletion so that the conflict metrics stay accurate
+	warnings, analyzeErr := r.analyzeRules(ctx, req.Name)
+	if analyzeErr != nil {
+		logger.Error(analyzeErr, "Failed to analyze rules", "name", req.Name)
+		return reconcile.Result{}, analyzeErr
+	}
+
 	// Update status if the resource exists
+	var result reconcile.Result
 	if err == nil {
+		rules, presetErr := specRules(rule)
+		if presetErr != nil {
+			warnings = append(warnings, presetErr.Error())
+		}
+		values, paramErr := resolveParameters(ctx, r.Mutator.apiReader(), rule)
+		ready := readyCondition(rule, rules, presetErr, values, paramErr)
 		rule.Status.ObservedGeneration = rule.Generation
-		rule.Status.Ready = true
-		rule.Status.RuleCount = len(rule.Spec.Rules)
+		rule.Status.Ready = ready.Status == metav1.ConditionTrue
+		rule.Status.RuleCount = len(rules)
+		rule.Status.Warnings = warnings
+		meta.SetStatusCondition(&rule.Status.Conditions, ready)
+		r.setMirrorsCondition(rule)
+		if r.setSuspendedCondition(rule) {
+			// Report the circuits turning half-open after the cooldown
+			result.RequeueAfter = r.Breaker.Cooldown()
+		}
 		now := r.now()
 		rule.Status.LastUpdateTime = &now
 
@@ -67,9 +111,220 @@ func (r *RulesWatcher) Reconcile(ctx context.Context, req reconcile.Request) (re
 			logger.Error(err, "Failed to update RegistryRewriteRule status", "name", req.Name)
 			return reconcile.Result{}, err
 		}
+		if ready.Reason == "ParameterError" {
+			// Retry reading the objects holding the parameters
+			return result, paramErr
+		}
+	}
+
+	return result, nil
+}
+
+// readyCondition returns the Ready condition of a resource, which is false
+// when its preset is invalid, one of its parameters can't be resolved, or the
+// replace template of one of its rules can't be rendered
+func readyCondition(
+	rule *devv1alpha1.RegistryRewriteRule, rules []devv1alpha1.Rule, presetErr error,
+	values map[string]string, paramErr error,
+) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               devv1alpha1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rule.Generation,
+	}
+
+	var renderErrs []string
+	for i, r := range rules {
+		if _, err := renderReplace(r.Replace, values); err != nil {
+			renderErrs = append(renderErrs, fmt.Sprintf("rule %d: %v", i, err))
+		}
+	}
+
+	switch {
+	case presetErr != nil:
+		condition.Reason = "InvalidPreset"
+		condition.Message = presetErr.Error()
+	case errors.Is(paramErr, errMissingParameter):
+		condition.Reason = "ParameterMissing"
+		condition.Message = paramErr.Error()
+	case paramErr != nil:
+		condition.Reason = "ParameterError"
+		condition.Message = paramErr.Error()
+	case len(renderErrs) > 0:
+		condition.Reason = "InvalidReplace"
+		condition.Message = strings.Join(renderErrs, "; ")
+	default:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Valid"
+		condition.Message = "The rules are used by the webhook"
+	}
+	return condition
+}
+
+// setMirrorsCondition sets the MirrorsHealthy condition of a resource whose
+// rules have targets, and removes it otherwise
+func (r *RulesWatcher) setMirrorsCondition(rule *devv1alpha1.RegistryRewriteRule) {
+	if r.Prober != nil {
+		if condition, ok := r.Prober.mirrorsCondition(rule); ok {
+			meta.SetStatusCondition(&rule.Status.Conditions, condition)
+			return
+		}
+	}
+	meta.RemoveStatusCondition(&rule.Status.Conditions, devv1alpha1.ConditionMirrorsHealthy)
+}
+
+// setSuspendedCondition sets the RulesSuspended condition of a resource from
+// the state of the circuit breakers of its rules, and reports whether a
+// circuit is open
+func (r *RulesWatcher) setSuspendedCondition(rule *devv1alpha1.RegistryRewriteRule) bool {
+	if r.Breaker == nil {
+		meta.RemoveStatusCondition(&rule.Status.Conditions, devv1alpha1.ConditionRulesSuspended)
+		return false
+	}
+
+	var suspended []string
+	open := false
+	rules, _ := specRules(rule)
+	for i := range rules {
+		state := r.Breaker.State(ruleRef(compiledRule{ruleName: rule.Name, index: i}))
+		if state != breaker.StateClosed {
+			suspended = append(suspended, fmt.Sprintf("rule %d is %s", i, state))
+		}
+		open = open || state == breaker.StateOpen
 	}
 
-	return reconcile.Result{}, nil
+	condition := metav1.Condition{
+		Type:               devv1alpha1.ConditionRulesSuspended,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rule.Generation,
+		Reason:             "CircuitsClosed",
+		Message:            "No rule is suspended",
+	}
+	if len(suspended) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "CircuitOpen"
+		condition.Message = "Rewritten images fail to pull: " + strings.Join(suspended, ", ")
+	}
+	meta.SetStatusCondition(&rule.Status.Conditions, condition)
+	return open
+}
+
+// analyzeRules detects shadowed and conflicting rules across all
+// RegistryRewriteRule resources, updates the conflict metrics and returns the
+// warnings concerning the named resource
+func (r *RulesWatcher) analyzeRules(ctx context.Context, name string) ([]string, error) {
+	ruleList := &devv1alpha1.RegistryRewriteRuleList{}
+	if err := r.List(ctx, ruleList); err != nil {
+		return nil, fmt.Errorf("failed to list RegistryRewriteRule: %w", err)
+	}
+
+	samples := r.SampleImages
+	if len(samples) == 0 {
+		samples = DefaultSampleImages
+	}
+	mirrorList := &devv1alpha1.RegistryMirrorList{}
+	if err := r.List(ctx, mirrorList); err != nil {
+		return nil, fmt.Errorf("failed to list RegistryMirror: %w", err)
+	}
+	parameters := resolveAllParameters(ctx, r.Mutator.apiReader(), ruleList.Items)
+	conflicts := detectConflicts(compileRules(ctx, ruleList.Items, parameters, mirrorList.Items...), samples)
+
+	counts := map[string]int{conflictShadowed: 0, conflictAmbiguous: 0}
+	var warnings []string
+	for _, c := range conflicts {
+		counts[c.kind]++
+		for _, resource := range c.resources() {
+			if resource == name {
+				warnings = append(warnings, c.message)
+				break
+			}
+		}
+	}
+	for kind, count := range counts {
+		ruleConflicts.WithLabelValues(kind).Set(float64(count))
+	}
+
+	return warnings, nil
+}
+
+// SetupWithManager sets up the watcher with the Manager. Any change to a
+// RegistryRewriteRule or a RegistryMirror re-enqueues all the rules, since
+// conflicts depend on the global rule set, and so does any change of the
+// health of a target. A change to a ConfigMap or Secret re-enqueues the rules
+// whose parameters or tag mappings read it, watching only its metadata.
+func (r *RulesWatcher) SetupWithManager(mgr ctrl.Manager) error {
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&devv1alpha1.RegistryRewriteRule{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&devv1alpha1.RegistryRewriteRule{},
+			handler.EnqueueRequestsFromMapFunc(r.enqueueAllRules),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&devv1alpha1.RegistryMirror{},
+			handler.EnqueueRequestsFromMapFunc(r.enqueueAllRules),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&corev1.ConfigMap{},
+			handler.EnqueueRequestsFromMapFunc(r.enqueueRulesReferencing("ConfigMap")), builder.OnlyMetadata).
+		Watches(&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.enqueueRulesReferencing("Secret")), builder.OnlyMetadata)
+	if r.Prober != nil {
+		b = b.WatchesRawSource(source.Channel(r.Prober.Changes(), handler.EnqueueRequestsFromMapFunc(r.enqueueAllRules)))
+	}
+	if r.Breaker != nil {
+		b = b.WatchesRawSource(source.Func(r.watchBreaker))
+	}
+	return b.Complete(r)
+}
+
+// watchBreaker enqueues all rules whenever a circuit changes state
+func (r *RulesWatcher) watchBreaker(ctx context.Context, queue workqueue.TypedRateLimitingInterface[reconcile.Request]) error {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.Breaker.Changes():
+				for _, req := range r.enqueueAllRules(ctx, nil) {
+					queue.Add(req)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// enqueueAllRules maps an event to a request for every RegistryRewriteRule
+func (r *RulesWatcher) enqueueAllRules(ctx context.Context, _ client.Object) []reconcile.Request {
+	ruleList := &devv1alpha1.RegistryRewriteRuleList{}
+	if err := r.List(ctx, ruleList); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list RegistryRewriteRule")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(ruleList.Items))
+	for _, rr := range ruleList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: rr.Name}})
+	}
+	return requests
+}
+
+// enqueueRulesReferencing returns a function mapping a change of a ConfigMap
+// or Secret of the given kind to requests for the RegistryRewriteRules whose
+// parameters read it
+func (r *RulesWatcher) enqueueRulesReferencing(kind string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		ruleList := &devv1alpha1.RegistryRewriteRuleList{}
+		if err := r.List(ctx, ruleList); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to list RegistryRewriteRule")
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for i := range ruleList.Items {
+			if referencesObject(&ruleList.Items[i], kind, client.ObjectKeyFromObject(obj)) {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: ruleList.Items[i].Name}})
+			}
+		}
+		return requests
+	}
 }
 
 // now returns the current time
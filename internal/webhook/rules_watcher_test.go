@@ -0,0 +1,70 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+	"github.com/flemzord/mutating-registry-webhook/internal/breaker"
+)
+
+var _ = Describe("RulesWatcher", func() {
+	It("should report the rules suspended by their circuit breaker", func() {
+		ctx := context.Background()
+		scheme := runtime.NewScheme()
+		Expect(devv1alpha1.AddToScheme(scheme)).To(Succeed())
+		c := fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(&devv1alpha1.RegistryRewriteRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "mirror"},
+				Spec: devv1alpha1.RegistryRewriteRuleSpec{
+					Rules: []devv1alpha1.Rule{{Match: `^docker\.io/(.*)`, Replace: `mirror.local/$1`}},
+				},
+			}).
+			WithStatusSubresource(&devv1alpha1.RegistryRewriteRule{}).
+			Build()
+		circuits := breaker.New(breaker.Config{FailureRate: 1, MinRequests: 1})
+		watcher := &RulesWatcher{Client: c, Mutator: &PodMutator{Client: c}, Breaker: circuits}
+		key := types.NamespacedName{Name: "mirror"}
+		updated := &devv1alpha1.RegistryRewriteRule{}
+
+		_, err := watcher.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Get(ctx, key, updated)).To(Succeed())
+		Expect(meta.IsStatusConditionFalse(updated.Status.Conditions, devv1alpha1.ConditionRulesSuspended)).To(BeTrue())
+
+		circuits.Record("mirror/0", true)
+		result, err := watcher.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(breaker.DefaultCooldown))
+		Expect(c.Get(ctx, key, updated)).To(Succeed())
+		condition := meta.FindStatusCondition(updated.Status.Conditions, devv1alpha1.ConditionRulesSuspended)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Message).To(ContainSubstring("rule 0 is open"))
+	})
+})
@@ -21,9 +21,41 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
 )
 
 func TestWebhook(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Webhook Suite")
 }
+
+// newTestMutator returns a PodMutator with a decoder, whose fake client holds
+// the given objects, such as rule resources and the objects they reference
+func newTestMutator(objs ...client.Object) *PodMutator {
+	scheme := runtime.NewScheme()
+	Expect(corev1.AddToScheme(scheme)).To(Succeed())
+	Expect(devv1alpha1.AddToScheme(scheme)).To(Succeed())
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&devv1alpha1.RegistryRewriteRule{}, &devv1alpha1.RegistryMirror{}).
+		Build()
+	mutator := &PodMutator{Client: c}
+	Expect(mutator.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+	return mutator
+}
+
+// newRuleResource returns a RegistryRewriteRule with the given name and rules
+func newRuleResource(name string, rules ...devv1alpha1.Rule) *devv1alpha1.RegistryRewriteRule {
+	return &devv1alpha1.RegistryRewriteRule{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       devv1alpha1.RegistryRewriteRuleSpec{Rules: rules},
+	}
+}
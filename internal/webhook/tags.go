@@ -0,0 +1,142 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get
+
+// latestTag is the tag pulled for images without a tag or digest
+const latestTag = "latest"
+
+// tagMapping is the data of a ConfigMap mapping tags, or the error reading it
+type tagMapping struct {
+	data map[string]string
+	err  error
+}
+
+// resolveTagMappings reads the ConfigMaps mapping the tags of the compiled
+// rules, once per ConfigMap. It returns an error when a ConfigMap can't be
+// read for another reason than not existing, so that the rules aren't cached;
+// missing ConfigMaps are picked up by the RulesWatcher once created.
+func resolveTagMappings(ctx context.Context, reader client.Reader, rules []compiledRule) error {
+	resolved := map[types.NamespacedName]tagMapping{}
+	var errs []error
+	for i := range rules {
+		tags := rules[i].rule.Tags
+		if tags == nil || tags.ConfigMapRef == nil {
+			continue
+		}
+
+		key := types.NamespacedName{Namespace: tags.ConfigMapRef.Namespace, Name: tags.ConfigMapRef.Name}
+		mapping, ok := resolved[key]
+		if !ok {
+			configMap := &corev1.ConfigMap{}
+			if err := reader.Get(ctx, key, configMap); err != nil {
+				mapping.err = fmt.Errorf("failed to get tag mapping ConfigMap %s: %w", key, err)
+				if !apierrors.IsNotFound(err) {
+					errs = append(errs, mapping.err)
+				}
+			}
+			mapping.data = configMap.Data
+			resolved[key] = mapping
+		}
+		rules[i].tagMapping = mapping
+	}
+	return errors.Join(errs...)
+}
+
+// referencesTagMapping reports whether a rule of a resource maps tags with
+// the given ConfigMap
+func referencesTagMapping(rr *devv1alpha1.RegistryRewriteRule, key types.NamespacedName) bool {
+	rules, _ := specRules(rr)
+	for _, rule := range rules {
+		if rule.Tags == nil || rule.Tags.ConfigMapRef == nil {
+			continue
+		}
+		if rule.Tags.ConfigMapRef.Name == key.Name && rule.Tags.ConfigMapRef.Namespace == key.Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteTag rewrites the tag of a parsed image according to the tag rewrite
+// of the rule. Images pinned by digest are returned unchanged.
+func rewriteTag(ref imageReference, rule compiledRule) (imageReference, error) {
+	tags := rule.rule.Tags
+	if tags == nil || ref.digest != "" {
+		return ref, nil
+	}
+
+	tag := ref.tag
+	if tag == "" {
+		tag = latestTag
+	}
+	for _, suffix := range tags.StripSuffixes {
+		if suffix != "" && strings.HasSuffix(tag, suffix) && tag != suffix {
+			tag = strings.TrimSuffix(tag, suffix)
+			break
+		}
+	}
+
+	mapped, ok := tags.Mapping[tag]
+	if tags.ConfigMapRef != nil {
+		if rule.tagMapping.err != nil {
+			return ref, rule.tagMapping.err
+		}
+		if value, found := rule.tagMapping.data[tag]; found {
+			mapped, ok = value, true
+		}
+	}
+	if ok {
+		tag = mapped
+	}
+
+	if tag == latestTag && ref.tag == "" {
+		// Keep images without a tag as they were
+		return ref, nil
+	}
+	ref.tag = tag
+	return ref, nil
+}
+
+// usesMutableTag reports whether a parsed image uses the latest tag, or no
+// tag, without being pinned by digest
+func usesMutableTag(ref imageReference) bool {
+	return ref.digest == "" && (ref.tag == "" || ref.tag == latestTag)
+}
+
+// mutableTagPolicy returns the mutable tag policy of a rule
+func mutableTagPolicy(rule compiledRule) devv1alpha1.MutableTagPolicy {
+	if rule.rule.MutableTagPolicy == "" {
+		return devv1alpha1.MutableTagPolicyAllow
+	}
+	return rule.rule.MutableTagPolicy
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var _ = Describe("Tag rewriting", func() {
+	var (
+		ctx     context.Context
+		mutator *PodMutator
+		pod     *corev1.Pod
+	)
+
+	setup := func(rules []devv1alpha1.Rule, objs ...client.Object) {
+		mutator = newTestMutator(append(objs, newRuleResource("tags", rules...))...)
+	}
+
+	pinnedTags := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "pinned-tags", Namespace: "registry-system"},
+		Data:       map[string]string{"latest": "1.27.0"},
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Image: "nginx"}},
+			},
+		}
+	})
+
+	It("should map tags from a ConfigMap, taking precedence over the mapping", func() {
+		setup([]devv1alpha1.Rule{{
+			Match:   `^docker\.io/(.*)`,
+			Replace: "mirror.local/$1",
+			Tags: &devv1alpha1.TagRewrite{
+				Mapping:      map[string]string{"latest": "1.25.0", "stable": "1.26.0"},
+				ConfigMapRef: &devv1alpha1.ConfigMapReference{Name: "pinned-tags", Namespace: "registry-system"},
+			},
+		}}, pinnedTags)
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "stable", Image: "nginx:stable"})
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/library/nginx:1.27.0"))
+		Expect(patchValue(resp, "/spec/containers/1/image")).To(Equal("mirror.local/library/nginx:1.26.0"))
+	})
+
+	It("should read the ConfigMap with the rules and again once it changed", func() {
+		configMapRef := &devv1alpha1.ConfigMapReference{Name: "pinned-tags", Namespace: "registry-system"}
+		setup([]devv1alpha1.Rule{{
+			Match:   `^docker\.io/(.*)`,
+			Replace: "mirror.local/$1",
+			Tags:    &devv1alpha1.TagRewrite{ConfigMapRef: configMapRef},
+		}}, pinnedTags.DeepCopy())
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/library/nginx:1.27.0"))
+
+		configMap := &corev1.ConfigMap{}
+		Expect(mutator.Client.Get(ctx, client.ObjectKeyFromObject(pinnedTags), configMap)).To(Succeed())
+		configMap.Data["latest"] = "1.28.0"
+		Expect(mutator.Client.Update(ctx, configMap)).To(Succeed())
+		resp = mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/library/nginx:1.27.0"))
+
+		watcher := &RulesWatcher{Client: mutator.Client, Mutator: mutator}
+		Expect(watcher.enqueueRulesReferencing("ConfigMap")(ctx, configMap)).
+			To(ConsistOf(reconcile.Request{NamespacedName: types.NamespacedName{Name: "tags"}}))
+		mutator.InvalidateCache()
+		resp = mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/library/nginx:1.28.0"))
+	})
+
+	It("should strip suffixes in the namespaces of the rule only", func() {
+		setup([]devv1alpha1.Rule{{
+			Match:      `^registry\.example\.com/`,
+			Tags:       &devv1alpha1.TagRewrite{StripSuffixes: []string{"-debug"}},
+			Conditions: &devv1alpha1.RuleConditions{Namespaces: []string{"production"}},
+		}})
+		pod.Spec.Containers[0].Image = "registry.example.com/team/app:1.4.2-debug"
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(resp.Patches).To(BeEmpty())
+
+		pod.Namespace = "production"
+		resp = mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("registry.example.com/team/app:1.4.2"))
+	})
+
+	It("should leave images pinned by digest untouched", func() {
+		setup([]devv1alpha1.Rule{{
+			Match: `^docker\.io/`,
+			Tags:  &devv1alpha1.TagRewrite{Mapping: map[string]string{"1.25": "1.26"}},
+		}})
+		pod.Spec.Containers[0].Image = "nginx:1.25@sha256:c5b1261d6d3e43071626931fc004f70149baeba2c8ec672bd4f27761f8e1ad6b"
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(resp.Patches).To(BeEmpty())
+	})
+
+	It("should keep the original image when the ConfigMap is missing", func() {
+		setup([]devv1alpha1.Rule{{
+			Match:   `^docker\.io/(.*)`,
+			Replace: "mirror.local/$1",
+			Tags: &devv1alpha1.TagRewrite{
+				ConfigMapRef: &devv1alpha1.ConfigMapReference{Name: "pinned-tags", Namespace: "registry-system"},
+			},
+		}})
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(BeNil())
+	})
+})
+
+var _ = Describe("Mutable tag policy", func() {
+	var (
+		ctx     context.Context
+		mutator *PodMutator
+		pod     *corev1.Pod
+	)
+
+	setup := func(rules ...devv1alpha1.Rule) {
+		mutator = newTestMutator(newRuleResource("policy", rules...))
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "production"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{Name: "latest", Image: "nginx:latest"},
+					{Name: "untagged", Image: "redis"},
+					{Name: "pinned", Image: "busybox:1.36"},
+				},
+			},
+		}
+	})
+
+	It("should refuse to rewrite images using a mutable tag", func() {
+		setup(devv1alpha1.Rule{
+			Match:            `^docker\.io/(.*)`,
+			Replace:          "mirror.local/$1",
+			MutableTagPolicy: devv1alpha1.MutableTagPolicyRefuse,
+		})
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(BeNil())
+		Expect(patchValue(resp, "/spec/containers/1/image")).To(BeNil())
+		Expect(patchValue(resp, "/spec/containers/2/image")).To(Equal("mirror.local/library/busybox:1.36"))
+	})
+
+	It("should rewrite mutable tags mapped to a pinned tag", func() {
+		setup(devv1alpha1.Rule{
+			Match:            `^docker\.io/(.*)`,
+			Replace:          "mirror.local/$1",
+			Tags:             &devv1alpha1.TagRewrite{Mapping: map[string]string{"latest": "7.2"}},
+			MutableTagPolicy: devv1alpha1.MutableTagPolicyRefuse,
+		})
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(patchValue(resp, "/spec/containers/0/image")).To(Equal("mirror.local/library/nginx:7.2"))
+		Expect(patchValue(resp, "/spec/containers/1/image")).To(Equal("mirror.local/library/redis:7.2"))
+	})
+
+	It("should warn about mutable tags in the namespaces of the rule", func() {
+		setup(devv1alpha1.Rule{
+			Match:            `^docker\.io/`,
+			MutableTagPolicy: devv1alpha1.MutableTagPolicyWarn,
+			Conditions:       &devv1alpha1.RuleConditions{Namespaces: []string{"production"}},
+		})
+
+		resp := mutator.Handle(ctx, newPodRequest(pod))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(resp.Patches).To(BeEmpty())
+		Expect(resp.Warnings).To(ConsistOf(
+			"image docker.io/library/nginx:latest uses a mutable tag, flagged by rule policy/0",
+			"image docker.io/library/redis uses a mutable tag, flagged by rule policy/0",
+		))
+
+		pod.Namespace = "default"
+		resp = mutator.Handle(ctx, newPodRequest(pod))
+		Expect(resp.Warnings).To(BeEmpty())
+	})
+})
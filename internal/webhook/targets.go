@@ -0,0 +1,174 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"hash/fnv"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var targetSelections = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "registry_rewriter_target_selections_total",
+	Help: "Total number of rewrites to each target registry of a rule",
+}, []string{"rule", "registry"})
+
+func init() {
+	metrics.Registry.MustRegister(targetSelections)
+}
+
+// selectTarget returns the target registry of a rewrite, according to the
+// target strategy of the rule
+func (m *PodMutator) selectTarget(rule compiledRule, pod *corev1.Pod) string {
+	var target string
+	switch rule.rule.TargetStrategy {
+	case devv1alpha1.TargetStrategyWeighted:
+		target = m.weightedTarget(rule.rule.Targets, workloadKey(pod))
+	case devv1alpha1.TargetStrategyTopology:
+		target = m.topologyTarget(rule.rule, pod)
+	default:
+		target = m.failoverTarget(rule.rule.Targets)
+	}
+	targetSelections.WithLabelValues(ruleRef(rule), target).Inc()
+	return target
+}
+
+// failoverTarget returns the first healthy target, or the first one when
+// none is healthy
+func (m *PodMutator) failoverTarget(targets []devv1alpha1.Target) string {
+	for _, target := range targets {
+		if m.Mirrors.Healthy(target.Registry) {
+			return target.Registry
+		}
+	}
+	return targets[0].Registry
+}
+
+// weightedTarget picks one of the healthy targets, or of all targets when
+// none is healthy, with a probability proportional to its weight. The choice
+// is deterministic for a given key.
+func (m *PodMutator) weightedTarget(targets []devv1alpha1.Target, key string) string {
+	var candidates []devv1alpha1.Target
+	for _, target := range targets {
+		if m.Mirrors.Healthy(target.Registry) {
+			candidates = append(candidates, target)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = append(candidates, targets...)
+	}
+
+	var total uint32
+	for _, target := range candidates {
+		total += uint32(max(target.Weight, 0))
+	}
+	if total == 0 {
+		// Spread evenly when all weights are zero
+		for i := range candidates {
+			candidates[i].Weight = 1
+		}
+		total = uint32(len(candidates))
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	point := h.Sum32() % total
+	for _, target := range candidates {
+		weight := uint32(max(target.Weight, 0))
+		if point < weight {
+			return target.Registry
+		}
+		point -= weight
+	}
+	return candidates[len(candidates)-1].Registry
+}
+
+// topologyTarget returns the first healthy target serving the topology
+// domain of the pod, falling back to the targets without topology values
+func (m *PodMutator) topologyTarget(rule devv1alpha1.Rule, pod *corev1.Pod) string {
+	key := rule.TopologyKey
+	if key == "" {
+		key = corev1.LabelTopologyZone
+	}
+	values := topologyValues(pod, key)
+
+	var local, defaults []devv1alpha1.Target
+	for _, target := range rule.Targets {
+		switch {
+		case len(target.TopologyValues) == 0:
+			defaults = append(defaults, target)
+		case slices.ContainsFunc(target.TopologyValues, func(v string) bool { return slices.Contains(values, v) }):
+			local = append(local, target)
+		}
+	}
+	candidates := slices.Concat(local, defaults)
+	if len(candidates) == 0 {
+		return m.failoverTarget(rule.Targets)
+	}
+	return m.failoverTarget(candidates)
+}
+
+// topologyValues returns the values of a topology key the pod is constrained
+// to by its node selector or its required node affinity, or nil when the pod
+// can run in any topology domain
+func topologyValues(pod *corev1.Pod, key string) []string {
+	if value, ok := pod.Spec.NodeSelector[key]; ok {
+		return []string{value}
+	}
+
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil ||
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return nil
+	}
+	// Terms are ORed, so every term must constrain the key
+	var values []string
+	for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		i := slices.IndexFunc(term.MatchExpressions, func(r corev1.NodeSelectorRequirement) bool {
+			return r.Key == key && r.Operator == corev1.NodeSelectorOpIn
+		})
+		if i < 0 {
+			return nil
+		}
+		values = append(values, term.MatchExpressions[i].Values...)
+	}
+	return values
+}
+
+// workloadKey identifies the workload of a pod, so that all its replicas
+// use the same target: the UID of its controller, its pod-template-hash, or
+// the pod itself
+func workloadKey(pod *corev1.Pod) string {
+	if owner := metav1.GetControllerOf(pod); owner != nil && owner.UID != "" {
+		return string(owner.UID)
+	}
+	if hash, ok := pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]; ok {
+		return pod.Namespace + "/" + hash
+	}
+	name := pod.Name
+	if name == "" {
+		name = pod.GenerateName
+	}
+	return pod.Namespace + "/" + name
+}
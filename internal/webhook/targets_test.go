@@ -0,0 +1,183 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+)
+
+var _ = Describe("Weighted targets", func() {
+	var (
+		mutator *PodMutator
+		rule    compiledRule
+	)
+
+	podOwnedBy := func(uid string) *corev1.Pod {
+		controller := true
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "web-",
+			Namespace:    "default",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "ReplicaSet",
+				Name:       "web",
+				UID:        types.UID(uid),
+				Controller: &controller,
+			}},
+		}}
+	}
+
+	BeforeEach(func() {
+		mutator = &PodMutator{}
+		rule = compiledRule{ruleName: "mirrors", rule: devv1alpha1.Rule{
+			TargetStrategy: devv1alpha1.TargetStrategyWeighted,
+			Targets: []devv1alpha1.Target{
+				{Registry: "a.example.com", Weight: 3},
+				{Registry: "b.example.com", Weight: 1},
+			},
+		}}
+	})
+
+	It("should select the same target for all replicas of a workload", func() {
+		target := mutator.selectTarget(rule, podOwnedBy("rs-uid"))
+		for range 10 {
+			Expect(mutator.selectTarget(rule, podOwnedBy("rs-uid"))).To(Equal(target))
+		}
+	})
+
+	It("should spread workloads according to the weights", func() {
+		counts := map[string]int{}
+		for i := range 1000 {
+			counts[mutator.selectTarget(rule, podOwnedBy(fmt.Sprintf("rs-%d", i)))]++
+		}
+		Expect(counts["a.example.com"]).To(BeNumerically("~", 750, 75))
+		Expect(counts["b.example.com"]).To(BeNumerically("~", 250, 75))
+	})
+
+	It("should use the pod-template-hash of pods without controller", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Labels:    map[string]string{"pod-template-hash": "5d4f"},
+		}}
+		Expect(workloadKey(pod)).To(Equal("default/5d4f"))
+		Expect(workloadKey(podOwnedBy("rs-uid"))).To(Equal("rs-uid"))
+	})
+
+	It("should spread workloads evenly when all weights are zero", func() {
+		rule.rule.Targets[0].Weight, rule.rule.Targets[1].Weight = 0, 0
+		counts := map[string]int{}
+		for i := range 100 {
+			counts[mutator.selectTarget(rule, podOwnedBy(fmt.Sprintf("rs-%d", i)))]++
+		}
+		Expect(counts).To(HaveLen(2))
+		Expect(rule.rule.Targets[0].Weight).To(BeZero())
+	})
+
+	It("should skip unhealthy targets", func() {
+		mutator.Mirrors = NewMirrorProber(nil, nil, 0)
+		mutator.Mirrors.healthy = map[string]bool{"a.example.com": false, "b.example.com": true}
+
+		selected := map[string]bool{}
+		for i := range 100 {
+			selected[mutator.selectTarget(rule, podOwnedBy(fmt.Sprintf("rs-%d", i)))] = true
+		}
+		Expect(selected).To(Equal(map[string]bool{"b.example.com": true}))
+	})
+})
+
+var _ = Describe("Topology-aware targets", func() {
+	var (
+		mutator *PodMutator
+		rule    compiledRule
+		pod     *corev1.Pod
+	)
+
+	BeforeEach(func() {
+		mutator = &PodMutator{}
+		rule = compiledRule{ruleName: "zonal", rule: devv1alpha1.Rule{
+			TargetStrategy: devv1alpha1.TargetStrategyTopology,
+			Targets: []devv1alpha1.Target{
+				{Registry: "mirror-a.example.com", TopologyValues: []string{"eu-west-1a"}},
+				{Registry: "mirror-b.example.com", TopologyValues: []string{"eu-west-1b"}},
+				{Registry: "mirror.example.com"},
+			},
+		}}
+		pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"}}
+	})
+
+	It("should select the target of the zone of the node selector", func() {
+		pod.Spec.NodeSelector = map[string]string{corev1.LabelTopologyZone: "eu-west-1b"}
+		Expect(mutator.selectTarget(rule, pod)).To(Equal("mirror-b.example.com"))
+	})
+
+	It("should select the target of the zone of the required node affinity", func() {
+		pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchExpressions: []corev1.NodeSelectorRequirement{{
+						Key:      corev1.LabelTopologyZone,
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   []string{"eu-west-1a"},
+					}},
+				}},
+			},
+		}}
+		Expect(mutator.selectTarget(rule, pod)).To(Equal("mirror-a.example.com"))
+	})
+
+	It("should honor the topology key", func() {
+		rule.rule.TopologyKey = "example.com/rack"
+		pod.Spec.NodeSelector = map[string]string{corev1.LabelTopologyZone: "eu-west-1b", "example.com/rack": "eu-west-1a"}
+		Expect(mutator.selectTarget(rule, pod)).To(Equal("mirror-a.example.com"))
+	})
+
+	It("should fall back to the default target", func() {
+		Expect(mutator.selectTarget(rule, pod)).To(Equal("mirror.example.com"))
+
+		pod.Spec.NodeSelector = map[string]string{corev1.LabelTopologyZone: "us-east-1a"}
+		Expect(mutator.selectTarget(rule, pod)).To(Equal("mirror.example.com"))
+
+		pod.Spec.NodeSelector = map[string]string{corev1.LabelTopologyZone: "eu-west-1a"}
+		mutator.Mirrors = NewMirrorProber(nil, nil, 0)
+		mutator.Mirrors.healthy = map[string]bool{"mirror-a.example.com": false}
+		Expect(mutator.selectTarget(rule, pod)).To(Equal("mirror.example.com"))
+	})
+
+	It("should not constrain pods whose affinity allows any zone", func() {
+		pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{MatchExpressions: []corev1.NodeSelectorRequirement{{
+						Key: corev1.LabelTopologyZone, Operator: corev1.NodeSelectorOpIn, Values: []string{"eu-west-1a"},
+					}}},
+					{MatchExpressions: []corev1.NodeSelectorRequirement{{
+						Key: corev1.LabelOSStable, Operator: corev1.NodeSelectorOpIn, Values: []string{"linux"},
+					}}},
+				},
+			},
+		}}
+		Expect(topologyValues(pod, corev1.LabelTopologyZone)).To(BeNil())
+	})
+})
@@ -0,0 +1,98 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/flemzord/mutating-registry-webhook/internal/registry"
+)
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+
+// registryReference returns the registry reference of a parsed image,
+// resolving the defaults of Docker Hub
+func registryReference(ref imageReference) registry.Reference {
+	host, repository := ref.domain, ref.path
+	if host == "" {
+		host = "docker.io"
+	}
+	if host == "docker.io" && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+
+	reference := ref.digest
+	if reference == "" {
+		reference = ref.tag
+	}
+	if reference == "" {
+		reference = "latest"
+	}
+
+	return registry.Reference{Registry: host, Repository: repository, Reference: reference}
+}
+
+// insecureTarget reports whether a registry is a target of the rule served
+// over plain HTTP
+func insecureTarget(rule compiledRule, host string) bool {
+	for _, target := range rule.rule.Targets {
+		if target.Registry == host && target.Insecure {
+			return true
+		}
+	}
+	return false
+}
+
+// registryCredentials returns the function reading the credentials of a
+// registry from the Secret referenced by the rule, if any
+func (m *PodMutator) registryCredentials(rule compiledRule, host string) registry.CredentialsFunc {
+	secretRef := rule.rule.CredentialsSecretRef
+	if secretRef == nil {
+		return nil
+	}
+
+	return func(ctx context.Context) (*registry.Credentials, error) {
+		secret := &corev1.Secret{}
+		key := types.NamespacedName{Namespace: secretRef.Namespace, Name: secretRef.Name}
+		if err := m.apiReader().Get(ctx, key, secret); err != nil {
+			return nil, fmt.Errorf("failed to get secret %s: %w", key, err)
+		}
+		return registry.CredentialsFromSecret(secret, host)
+	}
+}
+
+// credentialsIdentity returns the namespace/name of the Secret holding the
+// registry credentials of the rule, or an empty string for anonymous access
+func credentialsIdentity(rule compiledRule) string {
+	secretRef := rule.rule.CredentialsSecretRef
+	if secretRef == nil {
+		return ""
+	}
+	return secretRef.Namespace + "/" + secretRef.Name
+}
+
+// lookupManifest looks up the manifest of an image in its registry
+func (m *PodMutator) lookupManifest(ctx context.Context, ref imageReference, rule compiledRule) (registry.Manifest, error) {
+	target := registryReference(ref)
+	target.Insecure = insecureTarget(rule, target.Registry)
+	return m.Registry.HeadManifest(ctx, target, credentialsIdentity(rule), m.registryCredentials(rule, target.Registry))
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2025 flemzord.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	devv1alpha1 "github.com/flemzord/mutating-registry-webhook/api/v1alpha1"
+	"github.com/flemzord/mutating-registry-webhook/internal/registry"
+	"github.com/flemzord/mutating-registry-webhook/internal/registry/registrytest"
+)
+
+var _ = Describe("Rewritten image verification", func() {
+	var (
+		server  *registrytest.Server
+		mutator *PodMutator
+		pod     *corev1.Pod
+		ctx     context.Context
+	)
+
+	verifiedRules := func(rule devv1alpha1.Rule) []compiledRule {
+		rule.Match = `^docker\.io/(.*)`
+		rule.Replace = server.Host() + `/dockerhub/$1`
+		rule.Verify = true
+		return []compiledRule{{rule: rule, regex: regexp.MustCompile(rule.Match), replace: rule.Replace}}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		server = registrytest.NewServer()
+		server.AddManifest("dockerhub/library/nginx", "1.25", testDigest)
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		mutator = &PodMutator{
+			Client:   fake.NewClientBuilder().WithScheme(scheme).Build(),
+			Registry: &registry.Client{HTTPClient: server.Client()},
+		}
+		pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"}}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should rewrite images present in the target registry", func() {
+		rules := verifiedRules(devv1alpha1.Rule{})
+		Expect(mutator.mutateImage(ctx, "nginx:1.25", rules, pod)).To(Equal(server.Host() + "/dockerhub/library/nginx:1.25"))
+		Expect(mutator.mutateImage(ctx, "nginx@"+testDigest, rules, pod)).
+			To(Equal(server.Host() + "/dockerhub/library/nginx@" + testDigest))
+	})
+
+	It("should keep the original image when the target registry doesn't have it", func() {
+		rules := verifiedRules(devv1alpha1.Rule{})
+		Expect(mutator.mutateImage(ctx, "nginx:1.26", rules, pod)).To(Equal("nginx:1.26"))
+
+		rewrite, ok := mutator.rewriteImage(ctx, "nginx:1.26", rules, pod)
+		Expect(ok).To(BeTrue())
+		Expect(rewrite.err).To(MatchError(registry.ErrManifestNotFound))
+	})
+
+	It("should keep the original image once the admission deadline passed", func() {
+		rules := verifiedRules(devv1alpha1.Rule{})
+		expired, cancel := context.WithDeadline(ctx, time.Now())
+		defer cancel()
+
+		rewrite, ok := mutator.rewriteImage(expired, "nginx:1.25", rules, pod)
+		Expect(ok).To(BeTrue())
+		Expect(rewrite.err).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("should authenticate with the credentials of the referenced secret", func() {
+		server.Username, server.Password = "user", "secret"
+		rules := verifiedRules(devv1alpha1.Rule{
+			CredentialsSecretRef: &corev1.SecretReference{Namespace: "registry", Name: "mirror-credentials"},
+		})
+		Expect(mutator.mutateImage(ctx, "nginx:1.25", rules, pod)).To(Equal("nginx:1.25"))
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		mutator.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "registry", Name: "mirror-credentials"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(fmt.Sprintf(
+				`{"auths":{%q:{"username":"user","password":"secret"}}}`, server.Host()))},
+		}).Build()
+		Expect(mutator.mutateImage(ctx, "nginx:1.25", rules, pod)).To(Equal(server.Host() + "/dockerhub/library/nginx:1.25"))
+	})
+})
+
+var _ = Describe("Digest pinning", func() {
+	var (
+		server  *registrytest.Server
+		mutator *PodMutator
+		pod     *corev1.Pod
+		ctx     context.Context
+	)
+
+	pinnedRules := func(rule devv1alpha1.Rule) []compiledRule {
+		rule.Match = `^docker\.io/(.*)`
+		rule.Replace = server.Host() + `/dockerhub/$1`
+		return []compiledRule{{rule: rule, regex: regexp.MustCompile(rule.Match), replace: rule.Replace}}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		server = registrytest.NewServer()
+		server.AddManifest("dockerhub/library/nginx", "1.25", testDigest)
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		mutator = &PodMutator{
+			Client:   fake.NewClientBuilder().WithScheme(scheme).Build(),
+			Registry: &registry.Client{HTTPClient: server.Client()},
+		}
+		pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"}}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should replace the tag of the rewritten image with its digest", func() {
+		rules := pinnedRules(devv1alpha1.Rule{PinDigest: true})
+		rewrite, ok := mutator.rewriteImage(ctx, "nginx:1.25", rules, pod)
+		Expect(ok).To(BeTrue())
+		Expect(rewrite.err).NotTo(HaveOccurred())
+		Expect(rewrite.rewritten).To(Equal(server.Host() + "/dockerhub/library/nginx@" + testDigest))
+		Expect(rewrite.pinnedFrom).To(Equal(server.Host() + "/dockerhub/library/nginx:1.25"))
+	})
+
+	It("should pin the digests of every rule when enabled globally", func() {
+		mutator.PinDigests = true
+		Expect(mutator.mutateImage(ctx, "nginx:1.25", pinnedRules(devv1alpha1.Rule{}), pod)).
+			To(Equal(server.Host() + "/dockerhub/library/nginx@" + testDigest))
+	})
+
+	It("should fall back to the plain rewrite when the digest can't be resolved", func() {
+		rules := pinnedRules(devv1alpha1.Rule{PinDigest: true})
+		rewrite, ok := mutator.rewriteImage(ctx, "nginx:1.26", rules, pod)
+		Expect(ok).To(BeTrue())
+		Expect(rewrite.err).NotTo(HaveOccurred())
+		Expect(rewrite.rewritten).To(Equal(server.Host() + "/dockerhub/library/nginx:1.26"))
+		Expect(rewrite.pinnedFrom).To(BeEmpty())
+	})
+
+	It("should cache resolved digests", func() {
+		rules := pinnedRules(devv1alpha1.Rule{PinDigest: true})
+		mutator.mutateImage(ctx, "nginx:1.25", rules, pod)
+		requests := server.Requests()
+		mutator.mutateImage(ctx, "nginx:1.25", rules, pod)
+		Expect(server.Requests()).To(Equal(requests))
+	})
+
+	It("should record the pinned tags in an annotation", func() {
+		rules := pinnedRules(devv1alpha1.Rule{PinDigest: true})
+		rewrite, _ := mutator.rewriteImage(ctx, "nginx:1.25", rules, pod)
+		rewrite.container = "app"
+		Expect(annotateRewrites(pod, []imageRewrite{rewrite}, "")).To(Succeed())
+		Expect(pod.Annotations).To(HaveKeyWithValue(PinnedTagsAnnotation,
+			fmt.Sprintf(`{"app":"%s/dockerhub/library/nginx:1.25"}`, server.Host())))
+	})
+})